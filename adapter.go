@@ -0,0 +1,55 @@
+package squirrel
+
+import (
+	"math"
+	"sync"
+)
+
+// SeptemberAdapter makes a September usable as a SeptemberV2. Optional
+// interfaces such as BitErrorRater are implemented by the September field
+// rather than the adapter.
+//
+// Decisions of September don't tell why frames are dropped, so Results of
+// dropped frames have DroppedUnknown as Reason, and SINR is always NaN.
+type SeptemberAdapter struct {
+	September
+
+	underlying sync.Pool // *[]int
+}
+
+// AdaptSeptember returns a SeptemberV2 that makes decisions with september.
+func AdaptSeptember(september September) *SeptemberAdapter {
+	return &SeptemberAdapter{September: september}
+}
+
+// Implementation returns what optional interfaces of september, e.g.
+// BitErrorRater, should be looked up on: the September it adapts, if it's a
+// SeptemberAdapter, or september itself.
+func Implementation(september SeptemberV2) interface{} {
+	if adapter, ok := september.(*SeptemberAdapter); ok {
+		return adapter.September
+	}
+	return september
+}
+
+func (a *SeptemberAdapter) SendUnicast(source int, destination int, frame *Frame) Result {
+	if a.September.SendUnicast(source, destination, frame.Meta.PayloadSize) {
+		return Result{Destination: destination, Delivered: true, SINR: math.NaN()}
+	}
+	return Result{Destination: destination, Reason: DroppedUnknown, SINR: math.NaN()}
+}
+
+func (a *SeptemberAdapter) SendBroadcast(source int, frame *Frame, underlying []Result) []Result {
+	ids, _ := a.underlying.Get().(*[]int)
+	if ids == nil || len(*ids) < len(underlying) {
+		s := make([]int, len(underlying))
+		ids = &s
+	}
+	recipients := a.September.SendBroadcast(source, frame.Meta.PayloadSize, *ids)
+	results := underlying[:len(recipients)]
+	for i, id := range recipients {
+		results[i] = Result{Destination: id, Delivered: true, SINR: math.NaN()}
+	}
+	a.underlying.Put(ids)
+	return results
+}
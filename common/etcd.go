@@ -23,6 +23,52 @@ func GetEtcdValue(client *etcd.Client, key string) (value string, err error) {
 	return
 }
 
+// GetEtcdDir gets the directory node at key, along with everything under it.
+// If key does not exist, both dir and err are nil.
+func GetEtcdDir(client *etcd.Client, key string) (dir *etcd.Node, err error) {
+	var resp *etcd.Response
+	resp, err = client.Get(key, true, true)
+	if err != nil {
+		if IsEtcdNotFoundError(err) {
+			err = nil
+		}
+		return
+	}
+	if !resp.Node.Dir {
+		err = fmt.Errorf("%s is not a Dir node", key)
+		return
+	}
+	dir = resp.Node
+	return
+}
+
+// WatchEtcdDir calls update with the directory node at key, along with
+// everything under it, and then again each time anything under key changes.
+// update is called with nil if key does not exist. WatchEtcdDir blocks, and
+// only returns if getting or watching key fails.
+func WatchEtcdDir(client *etcd.Client, key string, update func(dir *etcd.Node)) error {
+	for {
+		var index uint64
+		resp, err := client.Get(key, true, true)
+		if err != nil {
+			if !IsEtcdNotFoundError(err) {
+				return err
+			}
+			update(nil)
+			index = err.(*etcd.EtcdError).Index
+		} else {
+			if !resp.Node.Dir {
+				return fmt.Errorf("%s is not a Dir node", key)
+			}
+			update(resp.Node)
+			index = resp.EtcdIndex
+		}
+		if _, err = client.Watch(key, index+1, true, nil, nil); err != nil {
+			return err
+		}
+	}
+}
+
 func IsEtcdNotFoundError(err error) bool {
 	etcdErr, ok := err.(*etcd.EtcdError)
 	if !ok {
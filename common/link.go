@@ -17,17 +17,55 @@ type Link struct {
 	decoder    *gob.Decoder
 
 	incoming      chan *ReusableSlice
-	outgoing      chan *ReusableSlice
+	outgoing      chan outgoingFrame
 	incomingError atomic.Value // error
 }
 
+type outgoingFrame struct {
+	buf *ReusableSlice
+
+	// encoded is true if buf holds a frame already encoded by
+	// AppendEncodedFrame.
+	encoded bool
+
+	// written, if not nil, is called once the frame is written, with the
+	// error if it couldn't be.
+	written func(err error)
+}
+
 func (l *Link) ReadFrame() (frame *ReusableSlice, ok bool) {
 	frame, ok = <-l.incoming
 	return
 }
 
 func (l *Link) WriteFrame(frame *ReusableSlice) {
-	l.outgoing <- frame
+	l.outgoing <- outgoingFrame{buf: frame}
+}
+
+// WriteEncodedFrame is like WriteFrame, but encoded holds a frame already
+// encoded by AppendEncodedFrame. This saves encoding the same frame for each
+// Link it is written to.
+func (l *Link) WriteEncodedFrame(encoded *ReusableSlice) {
+	l.outgoing <- outgoingFrame{buf: encoded, encoded: true}
+}
+
+// WriteEncodedFrameNotify is like WriteEncodedFrame, but calls written, if
+// it's not nil, from the routine writing to the connection once the frame is
+// written, with the error if it couldn't be.
+func (l *Link) WriteEncodedFrameNotify(encoded *ReusableSlice, written func(err error)) {
+	l.outgoing <- outgoingFrame{buf: encoded, encoded: true, written: written}
+}
+
+// TryWriteEncodedFrame is like WriteEncodedFrameNotify, but doesn't block. If
+// the outgoing queue is full, it returns false and encoded is still owned by
+// the caller.
+func (l *Link) TryWriteEncodedFrame(encoded *ReusableSlice, written func(err error)) bool {
+	select {
+	case l.outgoing <- outgoingFrame{buf: encoded, encoded: true, written: written}:
+		return true
+	default:
+		return false
+	}
 }
 
 func (l *Link) Done() {
@@ -36,7 +74,8 @@ func (l *Link) Done() {
 
 // IncomingError returns the error (if any) happened while decoding an incoming
 // message.  Note: if there's an error in encoding outgoing messages, it is
-// considered an implementation and log.Fatalf is called.
+// considered an implementation and log.Fatalf is called. If writing an encoded
+// frame fails, the connection is closed, which makes decoding fail.
 func (l *Link) IncomingError() error {
 	return *l.incomingError.Load().(*error)
 }
@@ -47,7 +86,7 @@ func NewLink(conn net.Conn) (link *Link) {
 		encoder:    gob.NewEncoder(conn),
 		decoder:    gob.NewDecoder(conn),
 		incoming:   make(chan *ReusableSlice, 64),
-		outgoing:   make(chan *ReusableSlice, 64),
+		outgoing:   make(chan outgoingFrame, 64),
 	}
 	var err error
 	link.incomingError.Store(&err)
@@ -120,16 +159,78 @@ func (link *Link) readRoutine() {
 }
 
 func (link *Link) writeRoutine() {
-	var err error
-	for buf := range link.outgoing {
-		if link.IncomingError() == nil {
-			if err = link.encoder.Encode(MSGFRAME); err != nil {
-				log.Fatalf("error encoding MSGFRAME: %v\n", err)
-			}
-			if err = link.encoder.Encode(buf.Slice()); err != nil {
-				log.Fatalf("error encoding MSGFRAME: %v\n", err)
+	var (
+		err      error
+		writeErr error // set once writing to the connection fails
+	)
+	for frame := range link.outgoing {
+		if err = link.IncomingError(); err == nil {
+			err = writeErr
+		}
+		if err == nil {
+			if frame.encoded {
+				// gob.Encoder doesn't buffer, so this doesn't interleave with
+				// what it writes.
+				if _, err = link.connection.Write(frame.buf.Slice()); err != nil {
+					// The connection is broken. Close it so that readRoutine
+					// fails and the Link gets torn down, and drop frames
+					// queued until then.
+					log.Printf("error writing encoded MSGFRAME, closing link: %v\n", err)
+					writeErr = err
+					link.connection.Close()
+				}
+			} else {
+				if err = link.encoder.Encode(MSGFRAME); err != nil {
+					log.Fatalf("error encoding MSGFRAME: %v\n", err)
+				}
+				if err = link.encoder.Encode(frame.buf.Slice()); err != nil {
+					log.Fatalf("error encoding MSGFRAME: %v\n", err)
+				}
 			}
 		}
-		buf.Done()
+		if frame.written != nil {
+			frame.written(err)
+		}
+		frame.buf.Done()
+	}
+}
+
+// AppendEncodedFrame appends to dst frame encoded into what Link's gob encoder
+// would write for it, i.e. a MSGFRAME followed by the frame as a []byte, and
+// returns the extended slice. Encoding of these types doesn't depend on what
+// the encoder has sent before, since they are predefined gob types that don't
+// need type definitions to be sent. See https://golang.org/pkg/encoding/gob/
+// for the format.
+func AppendEncodedFrame(dst []byte, frame []byte) []byte {
+	// MSGFRAME: length, type id of uint, singleton delta, value
+	dst = appendGobUint(dst, 3)
+	dst = append(dst, gobTypeIDUint, 0)
+	dst = appendGobUint(dst, uint64(MSGFRAME))
+	// frame: length, type id of []byte, singleton delta, byte count, bytes
+	var count [9]byte
+	countLen := len(appendGobUint(count[:0], uint64(len(frame))))
+	dst = appendGobUint(dst, uint64(2+countLen+len(frame)))
+	dst = append(dst, gobTypeIDBytes, 0)
+	dst = appendGobUint(dst, uint64(len(frame)))
+	return append(dst, frame...)
+}
+
+// gob type ids of uint and []byte, encoded as gob ints
+const (
+	gobTypeIDUint  = 3 << 1
+	gobTypeIDBytes = 5 << 1
+)
+
+func appendGobUint(dst []byte, v uint64) []byte {
+	if v < 0x80 {
+		return append(dst, byte(v))
+	}
+	var b [8]byte
+	n := 8
+	for ; v > 0; v >>= 8 {
+		n--
+		b[n] = byte(v)
 	}
+	dst = append(dst, byte(-(8 - n)))
+	return append(dst, b[n:]...)
 }
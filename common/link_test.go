@@ -0,0 +1,163 @@
+package common
+
+import (
+	"bytes"
+	"encoding/gob"
+	"io"
+	"net"
+	"sync"
+	"testing"
+)
+
+func TestAppendEncodedFrame(t *testing.T) {
+	frames := [][]byte{
+		{},
+		{0x01},
+		bytes.Repeat([]byte{0xab}, 127),
+		bytes.Repeat([]byte{0xcd}, 128),
+		bytes.Repeat([]byte{0xef}, 1522),
+		bytes.Repeat([]byte{0x42}, 70000),
+	}
+	var (
+		encoded  []byte
+		expected bytes.Buffer
+	)
+	encoder := gob.NewEncoder(&expected)
+	for _, frame := range frames {
+		encoded = AppendEncodedFrame(encoded, frame)
+		if err := encoder.Encode(MSGFRAME); err != nil {
+			t.Fatal(err)
+		}
+		if err := encoder.Encode(frame); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !bytes.Equal(encoded, expected.Bytes()) {
+		t.Errorf("encoded frames differ from what gob.Encoder writes")
+	}
+
+	decoder := gob.NewDecoder(bytes.NewReader(encoded))
+	for i, frame := range frames {
+		var (
+			msgType MsgType
+			decoded []byte
+		)
+		if err := decoder.Decode(&msgType); err != nil {
+			t.Fatalf("frame %d: decoding MsgType: %v", i, err)
+		}
+		if msgType != MSGFRAME {
+			t.Errorf("frame %d: MsgType is %d, expected %d", i, msgType, MSGFRAME)
+		}
+		if err := decoder.Decode(&decoded); err != nil {
+			t.Fatalf("frame %d: decoding frame: %v", i, err)
+		}
+		if !bytes.Equal(decoded, frame) {
+			t.Errorf("frame %d: decoded %d bytes, expected %d", i, len(decoded), len(frame))
+		}
+	}
+	if err := decoder.Decode(new(MsgType)); err != io.EOF {
+		t.Errorf("expected io.EOF after all frames, got %v", err)
+	}
+}
+
+func TestWriteEncodedFrameBrokenLink(t *testing.T) {
+	conn, peer := net.Pipe()
+	peer.Close()
+	link := NewLink(conn)
+	link.StartRoutines()
+	defer link.Done()
+
+	pool := NewSlicePool(1522)
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		encoded := pool.Get()
+		*encoded.SlicePtr() = AppendEncodedFrame(encoded.Slice()[:0], []byte{1, 2, 3})
+		link.WriteEncodedFrameNotify(encoded, func(err error) { errs <- err })
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err == nil {
+			t.Errorf("frame %d: expected a write error", i)
+		}
+	}
+	if _, ok := link.ReadFrame(); ok {
+		t.Error("expected the link to be torn down")
+	}
+}
+
+// benchmarkLinks returns n started Links whose peers discard whatever is
+// written to them.
+func benchmarkLinks(n int) []*Link {
+	links := make([]*Link, n)
+	for i := range links {
+		conn, peer := net.Pipe()
+		go io.Copy(io.Discard, peer)
+		links[i] = NewLink(conn)
+		links[i].StartRoutines()
+	}
+	return links
+}
+
+// flushLinks waits until everything written to links so far is written to
+// their connections, and tears them down.
+func flushLinks(links []*Link) {
+	var wg sync.WaitGroup
+	pool := NewSlicePool(16)
+	for _, link := range links {
+		encoded := pool.Get()
+		*encoded.SlicePtr() = AppendEncodedFrame(encoded.Slice()[:0], nil)
+		wg.Add(1)
+		link.WriteEncodedFrameNotify(encoded, func(error) { wg.Done() })
+	}
+	wg.Wait()
+	for _, link := range links {
+		link.Done()
+		link.connection.Close()
+	}
+}
+
+// Both benchmarks broadcast a small frame, such as an ARP request or a
+// routing protocol hello, to 100 Links, as master does.
+const (
+	benchmarkLinkCount = 100
+	benchmarkFrameSize = 80
+)
+
+func BenchmarkWriteFrame(b *testing.B) {
+	links := benchmarkLinks(benchmarkLinkCount)
+	pool := NewSlicePool(1522)
+	b.SetBytes(benchmarkFrameSize * int64(len(links)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		frame := pool.Get()
+		frame.Resize(benchmarkFrameSize)
+		for range links[1:] {
+			frame.AddOwner()
+		}
+		for _, link := range links {
+			link.WriteFrame(frame)
+		}
+	}
+	flushLinks(links)
+}
+
+func BenchmarkWriteEncodedFrame(b *testing.B) {
+	links := benchmarkLinks(benchmarkLinkCount)
+	pool := NewSlicePool(1522)
+	encodedPool := NewSlicePool(1522 + 16)
+	b.SetBytes(benchmarkFrameSize * int64(len(links)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		frame := pool.Get()
+		frame.Resize(benchmarkFrameSize)
+		encoded := encodedPool.Get()
+		*encoded.SlicePtr() = AppendEncodedFrame(encoded.Slice()[:0], frame.Slice())
+		frame.Done()
+		for range links[1:] {
+			encoded.AddOwner()
+		}
+		for _, link := range links {
+			link.WriteEncodedFrame(encoded)
+		}
+	}
+	flushLinks(links)
+}
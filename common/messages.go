@@ -17,9 +17,19 @@ const (
 // sent from client to master, representing request to join
 type JoinReq struct {
 	MACAddr net.HardwareAddr
+
+	// Name, if not empty, is a name configuration can refer to the node by,
+	// e.g. its hostname.
+	Name string
+
+	// Monitor requests to join as a monitor, which is not a node in the
+	// emulated network, but receives a copy of every frame that goes through
+	// master, encapsulated as described in monitor.go.
+	Monitor bool
 }
 
-// sent from master back to client, indicating assigned IP address and Mask
+// sent from master back to client, indicating assigned IP address and Mask.
+// Address and Mask are nil for a monitor.
 type JoinRsp struct {
 	Address net.IP
 	Mask    net.IPMask
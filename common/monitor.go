@@ -0,0 +1,68 @@
+package common
+
+import (
+	"encoding/binary"
+	"net"
+)
+
+// MonitorEtherType is the EtherType (IEEE 802 Local Experimental) of frames
+// sent to monitors. Each of them encapsulates a frame that went through master:
+//
+//	+----------------------------------------------------------+
+//	| Ethernet header                                          |
+//	|   destination: ff:ff:ff:ff:ff:ff                         |
+//	|   source:      source address of the encapsulated frame  |
+//	|   type:        MonitorEtherType                          |
+//	+----------------------------------------------------------+
+//	| version (1 byte): MonitorVersion                         |
+//	| flags (1 byte): MonitorDelivered if delivered to anyone  |
+//	| source identity (4 bytes)                                |
+//	| destination identity (4 bytes): 0 if group or unknown    |
+//	| number of recipients n (4 bytes)                         |
+//	| identities of recipients (4*n bytes)                     |
+//	+----------------------------------------------------------+
+//	| the encapsulated frame                                   |
+//	+----------------------------------------------------------+
+//
+// All integers are in network byte order.
+const MonitorEtherType = 0x88b5
+
+const (
+	MonitorVersion = 1
+
+	MonitorDelivered = 0x01
+)
+
+var monitorDestination = net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+
+// AppendMonitorFrame appends to dst an encapsulation of frame sent from source
+// to destination, that is delivered to recipients, and returns the extended
+// slice. If frame is too short to have a source address, the encapsulation has
+// a zero one.
+func AppendMonitorFrame(dst []byte, frame []byte, source int, destination int, recipients []int) []byte {
+	dst = append(dst, monitorDestination...)
+	if len(frame) >= 12 {
+		dst = append(dst, frame[6:12]...)
+	} else {
+		dst = append(dst, 0, 0, 0, 0, 0, 0)
+	}
+	dst = append(dst, byte(MonitorEtherType>>8), byte(MonitorEtherType&0xff))
+	var flags byte
+	if len(recipients) > 0 {
+		flags |= MonitorDelivered
+	}
+	dst = append(dst, MonitorVersion, flags)
+	dst = appendUint32(dst, source)
+	dst = appendUint32(dst, destination)
+	dst = appendUint32(dst, len(recipients))
+	for _, id := range recipients {
+		dst = appendUint32(dst, id)
+	}
+	return append(dst, frame...)
+}
+
+func appendUint32(dst []byte, v int) []byte {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], uint32(v))
+	return append(dst, b[:]...)
+}
@@ -0,0 +1,77 @@
+package common
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestAppendMonitorFrame(t *testing.T) {
+	frame := []byte{
+		0x33, 0x33, 0x00, 0x00, 0x00, 0x01, // destination
+		0x02, 0x00, 0x00, 0x00, 0x00, 0x07, // source
+		0x86, 0xdd, 0xaa,
+	}
+	tests := []struct {
+		name        string
+		frame       []byte
+		source      int
+		destination int
+		recipients  []int
+		expected    []byte
+	}{
+		{
+			name:        "delivered",
+			frame:       frame,
+			source:      7,
+			destination: 0,
+			recipients:  []int{1, 258},
+			expected: append([]byte{
+				0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+				0x02, 0x00, 0x00, 0x00, 0x00, 0x07,
+				0x88, 0xb5,
+				MonitorVersion, MonitorDelivered,
+				0, 0, 0, 7,
+				0, 0, 0, 0,
+				0, 0, 0, 2,
+				0, 0, 0, 1,
+				0, 0, 1, 2,
+			}, frame...),
+		},
+		{
+			name:        "dropped",
+			frame:       frame,
+			source:      7,
+			destination: 3,
+			expected: append([]byte{
+				0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+				0x02, 0x00, 0x00, 0x00, 0x00, 0x07,
+				0x88, 0xb5,
+				MonitorVersion, 0,
+				0, 0, 0, 7,
+				0, 0, 0, 3,
+				0, 0, 0, 0,
+			}, frame...),
+		},
+		{
+			name:        "too short",
+			frame:       frame[:8],
+			source:      7,
+			destination: 3,
+			expected: append([]byte{
+				0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+				0, 0, 0, 0, 0, 0,
+				0x88, 0xb5,
+				MonitorVersion, 0,
+				0, 0, 0, 7,
+				0, 0, 0, 3,
+				0, 0, 0, 0,
+			}, frame[:8]...),
+		},
+	}
+	for _, test := range tests {
+		got := AppendMonitorFrame(nil, test.frame, test.source, test.destination, test.recipients)
+		if !bytes.Equal(got, test.expected) {
+			t.Errorf("%s: got\n%x, expected\n%x", test.name, got, test.expected)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package squirrel
+
+import (
+	"encoding/binary"
+	"time"
+
+	"github.com/songgao/packets/ethernet"
+)
+
+// FrameMeta is metadata Master parses from a frame. Fields that don't apply to
+// the frame, e.g. ports of a frame that doesn't carry TCP, UDP or SCTP, are -1.
+type FrameMeta struct {
+
+	// PayloadSize is the size(in bytes) of the Ethernet payload, i.e. the size
+	// September gets in SendUnicast and SendBroadcast.
+	PayloadSize int
+
+	// EtherType is the EtherType of the payload, after any 802.1Q tags.
+	EtherType int
+
+	// Priority is the 802.1Q priority code point of the outer tag.
+	Priority int
+
+	// IPProtocol is the protocol number (next header for IPv6, after
+	// extension headers) of an IPv4 or IPv6 packet.
+	IPProtocol int
+
+	// DSCP is the differentiated services code point of an IPv4 or IPv6
+	// packet.
+	DSCP int
+
+	// SrcPort and DstPort are ports of a TCP, UDP or SCTP segment. They're
+	// only set for the first fragment of a fragmented IPv4 packet.
+	SrcPort int
+	DstPort int
+
+	// Received is when Master read the frame from its sender, in emulated
+	// time.
+	Received time.Time
+}
+
+// Frame is a read-only view of a frame going through Master, along with its
+// metadata. Data must not be modified, nor retained after the method it's
+// passed to returns.
+type Frame struct {
+	Data []byte
+	Meta FrameMeta
+}
+
+// ParseFrameMeta parses metadata of frame in data, which was received at
+// received.
+func ParseFrameMeta(data []byte, received time.Time) (meta FrameMeta) {
+	meta = FrameMeta{EtherType: -1, Priority: -1, IPProtocol: -1, DSCP: -1, SrcPort: -1, DstPort: -1, Received: received}
+	frame := ethernet.Frame(data)
+	if len(frame) < 14 || len(frame) < 14+int(frame.Tagging()) {
+		return
+	}
+	if frame.Tagging() != ethernet.NotTagged {
+		meta.Priority = int(frame[14] >> 5)
+	}
+	et := frame.Ethertype()
+	meta.EtherType = int(et[0])<<8 | int(et[1])
+	packet := frame.Payload()
+	meta.PayloadSize = len(packet)
+	var transport []byte
+	switch et {
+	case ethernet.IPv4:
+		if len(packet) < 20 {
+			return
+		}
+		meta.IPProtocol = int(packet[9])
+		meta.DSCP = int(packet[1] >> 2)
+		ihl := int(packet[0]&0x0f) * 4
+		// only the first fragment has transport header
+		if binary.BigEndian.Uint16(packet[6:8])&0x1fff == 0 && len(packet) >= ihl {
+			transport = packet[ihl:]
+		}
+	case ethernet.IPv6:
+		if len(packet) < 40 {
+			return
+		}
+		meta.DSCP = int(packet[0]&0x0f)<<2 | int(packet[1]>>6)
+		next := packet[6]
+		packet = packet[40:]
+		for next == 0 || next == 43 || next == 60 {
+			if len(packet) < 8 || len(packet) < (int(packet[1])+1)*8 {
+				return
+			}
+			next, packet = packet[0], packet[(int(packet[1])+1)*8:]
+		}
+		meta.IPProtocol = int(next)
+		transport = packet
+	default:
+		return
+	}
+	switch meta.IPProtocol {
+	case 6, 17, 132: // TCP, UDP, SCTP
+		if len(transport) >= 4 {
+			meta.SrcPort = int(binary.BigEndian.Uint16(transport[0:2]))
+			meta.DstPort = int(binary.BigEndian.Uint16(transport[2:4]))
+		}
+	}
+	return
+}
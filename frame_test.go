@@ -0,0 +1,96 @@
+package squirrel
+
+import (
+	"testing"
+	"time"
+)
+
+// ethernetFrame returns a frame with header followed by payload. header holds
+// whatever goes after the MAC addresses, i.e. tags and the EtherType.
+func ethernetFrame(header []byte, payload ...byte) []byte {
+	frame := []byte{0x02, 0, 0, 0, 0, 2, 0x02, 0, 0, 0, 0, 1}
+	return append(append(frame, header...), payload...)
+}
+
+func ipv4(protocol byte, tos byte, fragment uint16, transport ...byte) []byte {
+	header := []byte{0x45, tos, 0, 0, 0, 0, byte(fragment >> 8), byte(fragment), 64, protocol, 0, 0, 10, 0, 0, 1, 10, 0, 0, 2}
+	return append(header, transport...)
+}
+
+func ipv6(next byte, trafficClass byte, rest ...byte) []byte {
+	header := make([]byte, 40)
+	header[0], header[1], header[6] = 0x60|trafficClass>>4, trafficClass<<4, next
+	return append(header, rest...)
+}
+
+func TestParseFrameMeta(t *testing.T) {
+	received := time.Unix(1500000000, 0)
+	ports := []byte{0x9c, 0x40, 0x00, 0x35} // 40000 -> 53
+	tests := []struct {
+		name     string
+		data     []byte
+		expected FrameMeta
+	}{
+		{
+			name:     "too short",
+			data:     []byte{1, 2, 3},
+			expected: FrameMeta{EtherType: -1, Priority: -1, IPProtocol: -1, DSCP: -1, SrcPort: -1, DstPort: -1},
+		},
+		{
+			name:     "truncated tag",
+			data:     ethernetFrame([]byte{0x81, 0x00, 0xa0}),
+			expected: FrameMeta{EtherType: -1, Priority: -1, IPProtocol: -1, DSCP: -1, SrcPort: -1, DstPort: -1},
+		},
+		{
+			name:     "ARP",
+			data:     ethernetFrame([]byte{0x08, 0x06}, make([]byte, 28)...),
+			expected: FrameMeta{PayloadSize: 28, EtherType: 0x0806, Priority: -1, IPProtocol: -1, DSCP: -1, SrcPort: -1, DstPort: -1},
+		},
+		{
+			name:     "IPv4 UDP",
+			data:     ethernetFrame([]byte{0x08, 0x00}, ipv4(17, 46<<2, 0, ports...)...),
+			expected: FrameMeta{PayloadSize: 24, EtherType: 0x0800, Priority: -1, IPProtocol: 17, DSCP: 46, SrcPort: 40000, DstPort: 53},
+		},
+		{
+			name:     "IPv4 later fragment",
+			data:     ethernetFrame([]byte{0x08, 0x00}, ipv4(6, 0, 0x0010, ports...)...),
+			expected: FrameMeta{PayloadSize: 24, EtherType: 0x0800, Priority: -1, IPProtocol: 6, DSCP: 0, SrcPort: -1, DstPort: -1},
+		},
+		{
+			name:     "IPv4 ICMP",
+			data:     ethernetFrame([]byte{0x08, 0x00}, ipv4(1, 0, 0, 8, 0, 0, 0)...),
+			expected: FrameMeta{PayloadSize: 24, EtherType: 0x0800, Priority: -1, IPProtocol: 1, DSCP: 0, SrcPort: -1, DstPort: -1},
+		},
+		{
+			name:     "IPv4 truncated",
+			data:     ethernetFrame([]byte{0x08, 0x00}, 0x45, 0),
+			expected: FrameMeta{PayloadSize: 2, EtherType: 0x0800, Priority: -1, IPProtocol: -1, DSCP: -1, SrcPort: -1, DstPort: -1},
+		},
+		{
+			name:     "802.1Q tagged IPv4 TCP",
+			data:     ethernetFrame([]byte{0x81, 0x00, 0xa0, 0x05, 0x08, 0x00}, ipv4(6, 0, 0x4000, ports...)...),
+			expected: FrameMeta{PayloadSize: 24, EtherType: 0x0800, Priority: 5, IPProtocol: 6, DSCP: 0, SrcPort: 40000, DstPort: 53},
+		},
+		{
+			name:     "IPv6 UDP",
+			data:     ethernetFrame([]byte{0x86, 0xdd}, ipv6(17, 10<<2, ports...)...),
+			expected: FrameMeta{PayloadSize: 44, EtherType: 0x86dd, Priority: -1, IPProtocol: 17, DSCP: 10, SrcPort: 40000, DstPort: 53},
+		},
+		{
+			name:     "IPv6 extension headers",
+			data:     ethernetFrame([]byte{0x86, 0xdd}, ipv6(0, 0, append([]byte{60, 0, 0, 0, 0, 0, 0, 0, 132, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, ports...)...)...),
+			expected: FrameMeta{PayloadSize: 68, EtherType: 0x86dd, Priority: -1, IPProtocol: 132, DSCP: 0, SrcPort: 40000, DstPort: 53},
+		},
+		{
+			name:     "IPv6 truncated extension header",
+			data:     ethernetFrame([]byte{0x86, 0xdd}, ipv6(0, 0, 17, 1, 0, 0)...),
+			expected: FrameMeta{PayloadSize: 44, EtherType: 0x86dd, Priority: -1, IPProtocol: -1, DSCP: 0, SrcPort: -1, DstPort: -1},
+		},
+	}
+	for _, test := range tests {
+		test.expected.Received = received
+		if got := ParseFrameMeta(test.data, received); got != test.expected {
+			t.Errorf("%s: got %+v, expected %+v", test.name, got, test.expected)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package squirrel
+
+import (
+	"fmt"
+	"sync"
+)
+
+var (
+	frameInterceptorsMu sync.RWMutex
+	frameInterceptors   = make(map[string]func() FrameInterceptor)
+)
+
+// RegisterFrameInterceptor makes a FrameInterceptor available by name to
+// configuration of the interceptor chain of master. It's intended to be
+// called from init() of the package implementing the interceptor, so that
+// linking the package into master, e.g. with a blank import, is all it takes.
+// It panics if name is already registered.
+func RegisterFrameInterceptor(name string, constructor func() FrameInterceptor) {
+	frameInterceptorsMu.Lock()
+	defer frameInterceptorsMu.Unlock()
+	if constructor == nil {
+		panic("squirrel: FrameInterceptor constructor is nil")
+	}
+	if _, ok := frameInterceptors[name]; ok {
+		panic(fmt.Sprintf("squirrel: FrameInterceptor %q is registered twice", name))
+	}
+	frameInterceptors[name] = constructor
+}
+
+// NewFrameInterceptor returns a new FrameInterceptor registered as name, or
+// nil if none is.
+func NewFrameInterceptor(name string) FrameInterceptor {
+	frameInterceptorsMu.RLock()
+	constructor := frameInterceptors[name]
+	frameInterceptorsMu.RUnlock()
+	if constructor == nil {
+		return nil
+	}
+	return constructor()
+}
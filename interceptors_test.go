@@ -0,0 +1,42 @@
+package squirrel
+
+import (
+	"sync/atomic"
+	"testing"
+
+	"github.com/coreos/go-etcd/etcd"
+)
+
+type nopInterceptor struct{ id int }
+
+func (*nopInterceptor) ParametersHelp() string                      { return "" }
+func (*nopInterceptor) Configure(*etcd.Node) error                  { return nil }
+func (*nopInterceptor) BeforeDecision(frame *InterceptedFrame) bool { return true }
+func (*nopInterceptor) AfterDecision(frame *InterceptedFrame, recipients []int) []int {
+	return recipients
+}
+
+var created int32
+
+func init() {
+	RegisterFrameInterceptor("test_nop", func() FrameInterceptor {
+		return &nopInterceptor{id: int(atomic.AddInt32(&created, 1))}
+	})
+}
+
+func TestRegisterFrameInterceptor(t *testing.T) {
+	first, second := NewFrameInterceptor("test_nop"), NewFrameInterceptor("test_nop")
+	if first == nil || second == nil || first == second {
+		t.Fatalf("got %v and %v, expected two new interceptors", first, second)
+	}
+	if NewFrameInterceptor("test_unknown") != nil {
+		t.Error("got an interceptor that is not registered")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("registering a name twice doesn't panic")
+		}
+	}()
+	RegisterFrameInterceptor("test_nop", func() FrameInterceptor { return new(nopInterceptor) })
+}
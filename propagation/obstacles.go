@@ -0,0 +1,233 @@
+package propagation
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+
+	"github.com/squirrel-land/squirrel"
+)
+
+// Obstacle is an obstacle on the X-Y plane, e.g. a building or a wall, made of
+// segments that a link crossing is attenuated by.
+type Obstacle struct {
+	Name string
+
+	// Attenuation is how much(in dB) a link is attenuated each time it crosses
+	// a segment of the obstacle. It's +Inf for an opaque obstacle.
+	Attenuation float64
+
+	segments [][2][2]float64
+
+	// bounding box
+	min, max [2]float64
+}
+
+// NewObstacle returns an Obstacle made of lines, each a series of at least 2
+// points(X and Y) joined by segments. A polygon is a line that ends at the
+// point it starts at.
+func NewObstacle(name string, attenuation float64, lines [][][2]float64) (*Obstacle, error) {
+	o := &Obstacle{Name: name, Attenuation: attenuation}
+	o.min = [2]float64{math.Inf(1), math.Inf(1)}
+	o.max = [2]float64{math.Inf(-1), math.Inf(-1)}
+	for _, line := range lines {
+		if len(line) < 2 {
+			return nil, fmt.Errorf("obstacle %q has a line with less than 2 points", name)
+		}
+		for i, p := range line {
+			o.min = [2]float64{math.Min(o.min[0], p[0]), math.Min(o.min[1], p[1])}
+			o.max = [2]float64{math.Max(o.max[0], p[0]), math.Max(o.max[1], p[1])}
+			if i > 0 {
+				o.segments = append(o.segments, [2][2]float64{line[i-1], p})
+			}
+		}
+	}
+	if len(o.segments) == 0 {
+		return nil, fmt.Errorf("obstacle %q has no segments", name)
+	}
+	return o, nil
+}
+
+// orientation is positive if c is to the left of the line from a to b, and
+// negative if to the right.
+func orientation(a, b, c [2]float64) float64 {
+	return (b[0]-a[0])*(c[1]-a[1]) - (b[1]-a[1])*(c[0]-a[0])
+}
+
+// Crossings returns how many segments of o the link from a to b crosses. A
+// point exactly on a line counts as being on one side of it, so that a link
+// through a point where two segments meet crosses only one of them.
+func (o *Obstacle) Crossings(a, b [2]float64) (n int) {
+	if math.Max(a[0], b[0]) < o.min[0] || math.Min(a[0], b[0]) > o.max[0] ||
+		math.Max(a[1], b[1]) < o.min[1] || math.Min(a[1], b[1]) > o.max[1] {
+		return
+	}
+	for _, s := range o.segments {
+		if (orientation(a, b, s[0]) > 0) != (orientation(a, b, s[1]) > 0) &&
+			(orientation(s[0], s[1], a) > 0) != (orientation(s[0], s[1], b) > 0) {
+			n++
+		}
+	}
+	return
+}
+
+// Obstacles is a layer of obstacles that attenuate or block links between
+// positions. It only considers X and Y of positions, as if obstacles were
+// infinitely high.
+type Obstacles struct {
+	List []*Obstacle
+}
+
+func plane(p *squirrel.Position) [2]float64 {
+	return [2]float64{p.X, p.Y}
+}
+
+// Attenuation returns how much(in dB) obstacles attenuate the link between a
+// and b. It's +Inf if an opaque obstacle is in the way.
+func (o *Obstacles) Attenuation(a, b *squirrel.Position) (attenuation float64) {
+	pa, pb := plane(a), plane(b)
+	for _, obstacle := range o.List {
+		if n := obstacle.Crossings(pa, pb); n > 0 {
+			attenuation += float64(n) * obstacle.Attenuation
+		}
+	}
+	return
+}
+
+// LineOfSight returns whether no obstacle is in the way between a and b.
+func (o *Obstacles) LineOfSight(a, b *squirrel.Position) bool {
+	pa, pb := plane(a), plane(b)
+	for _, obstacle := range o.List {
+		if obstacle.Crossings(pa, pb) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// AttenuationBetween is like Attenuation, for nodes with identities a and b
+// in positionManager.
+func (o *Obstacles) AttenuationBetween(positionManager squirrel.PositionManager, a, b int) (float64, error) {
+	pa, pb, err := positions(positionManager, a, b)
+	if err != nil {
+		return 0, err
+	}
+	return o.Attenuation(&pa, &pb), nil
+}
+
+// LineOfSightBetween is like LineOfSight, for nodes with identities a and b
+// in positionManager.
+func (o *Obstacles) LineOfSightBetween(positionManager squirrel.PositionManager, a, b int) (bool, error) {
+	pa, pb, err := positions(positionManager, a, b)
+	if err != nil {
+		return false, err
+	}
+	return o.LineOfSight(&pa, &pb), nil
+}
+
+func positions(positionManager squirrel.PositionManager, a, b int) (pa, pb squirrel.Position, err error) {
+	if pa, err = positionManager.Get(a); err != nil {
+		return
+	}
+	pb, err = positionManager.Get(b)
+	return
+}
+
+// ReadGeoJSON reads obstacles from a GeoJSON FeatureCollection. Coordinates
+// are X and Y in the same space, and unit(meters), as positions of nodes,
+// rather than longitude and latitude. Each feature is an obstacle:
+//
+//   - a Polygon or MultiPolygon, e.g. a building, is crossed once for each
+//     of its edges a link goes through, i.e. once for each wall;
+//   - a LineString or MultiLineString, e.g. a wall, is crossed once for each
+//     of its segments a link goes through.
+//
+// Properties of a feature can have attenuation_db, the attenuation per
+// crossing, which is defaultAttenuation if it's not set; opaque, which blocks
+// links entirely if it's true; and name.
+func ReadGeoJSON(r io.Reader, defaultAttenuation float64) (obstacles *Obstacles, err error) {
+	var collection struct {
+		Type     string `json:"type"`
+		Features []struct {
+			Geometry struct {
+				Type        string          `json:"type"`
+				Coordinates json.RawMessage `json:"coordinates"`
+			} `json:"geometry"`
+			Properties struct {
+				Name        string   `json:"name"`
+				Attenuation *float64 `json:"attenuation_db"`
+				Opaque      bool     `json:"opaque"`
+			} `json:"properties"`
+		} `json:"features"`
+	}
+	if err = json.NewDecoder(r).Decode(&collection); err != nil {
+		return
+	}
+	if collection.Type != "FeatureCollection" {
+		return nil, fmt.Errorf("expected a FeatureCollection, got %q", collection.Type)
+	}
+	obstacles = new(Obstacles)
+	for i, f := range collection.Features {
+		name := f.Properties.Name
+		if name == "" {
+			name = fmt.Sprintf("#%d", i)
+		}
+		var lines [][][2]float64
+		switch f.Geometry.Type {
+		case "LineString":
+			var line [][2]float64
+			err = json.Unmarshal(f.Geometry.Coordinates, &line)
+			lines = [][][2]float64{line}
+		case "MultiLineString", "Polygon":
+			err = json.Unmarshal(f.Geometry.Coordinates, &lines)
+		case "MultiPolygon":
+			var polygons [][][][2]float64
+			err = json.Unmarshal(f.Geometry.Coordinates, &polygons)
+			for _, polygon := range polygons {
+				lines = append(lines, polygon...)
+			}
+		default:
+			err = fmt.Errorf("unsupported geometry %q", f.Geometry.Type)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("feature %s: %v", name, err)
+		}
+		attenuation := defaultAttenuation
+		if f.Properties.Attenuation != nil {
+			attenuation = *f.Properties.Attenuation
+		}
+		if f.Properties.Opaque {
+			attenuation = math.Inf(1)
+		}
+		var obstacle *Obstacle
+		if obstacle, err = NewObstacle(name, attenuation, lines); err != nil {
+			return nil, err
+		}
+		obstacles.List = append(obstacles.List, obstacle)
+	}
+	return
+}
+
+// LoadGeoJSON reads obstacles from a GeoJSON file at path, as ReadGeoJSON
+// does.
+func LoadGeoJSON(path string, defaultAttenuation float64) (*Obstacles, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadGeoJSON(f, defaultAttenuation)
+}
+
+// Obstructed is a PathLoss that adds attenuation by Obstacles to another
+// PathLoss.
+type Obstructed struct {
+	PathLoss
+	Obstacles *Obstacles
+}
+
+func (o *Obstructed) Loss(tx, rx *squirrel.Position) float64 {
+	return o.PathLoss.Loss(tx, rx) + o.Obstacles.Attenuation(tx, rx)
+}
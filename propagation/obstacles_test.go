@@ -0,0 +1,96 @@
+package propagation
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/squirrel-land/squirrel"
+)
+
+func TestObstacleCrossings(t *testing.T) {
+	square := [][][2]float64{{{0, 0}, {10, 0}, {10, 10}, {0, 10}, {0, 0}}}
+	wall := [][][2]float64{{{5, -5}, {5, 5}}}
+	bent := [][][2]float64{{{5, -5}, {5, 0}, {5, 5}}}
+	tests := []struct {
+		name     string
+		lines    [][][2]float64
+		a, b     [2]float64
+		expected int
+	}{
+		{"through a building", square, [2]float64{-5, 5}, [2]float64{15, 5}, 2},
+		{"into a building", square, [2]float64{-5, 5}, [2]float64{5, 5}, 1},
+		{"within a building", square, [2]float64{2, 2}, [2]float64{8, 8}, 0},
+		{"past a building", square, [2]float64{-5, 15}, [2]float64{15, 15}, 0},
+		{"diagonally through corners", square, [2]float64{-1, -1}, [2]float64{11, 11}, 2},
+		{"through a wall", wall, [2]float64{0, 0}, [2]float64{10, 0}, 1},
+		{"short of a wall", wall, [2]float64{0, 0}, [2]float64{4, 0}, 0},
+		{"beyond the end of a wall", wall, [2]float64{0, 6}, [2]float64{10, 6}, 0},
+		{"parallel to a wall", wall, [2]float64{4, -10}, [2]float64{4, 10}, 0},
+		{"through a joint of segments", bent, [2]float64{0, 0}, [2]float64{10, 0}, 1},
+		{"through a joint, askew", bent, [2]float64{0, -3}, [2]float64{10, 3}, 1},
+	}
+	for _, test := range tests {
+		o, err := NewObstacle(test.name, 1, test.lines)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := o.Crossings(test.a, test.b); got != test.expected {
+			t.Errorf("%s: %d crossings, expected %d", test.name, got, test.expected)
+		}
+		if got := o.Crossings(test.b, test.a); got != test.expected {
+			t.Errorf("%s, reversed: %d crossings, expected %d", test.name, got, test.expected)
+		}
+	}
+}
+
+func TestNewObstacleErrors(t *testing.T) {
+	for _, lines := range [][][][2]float64{nil, {{{0, 0}}}} {
+		if _, err := NewObstacle("x", 1, lines); err == nil {
+			t.Errorf("%v: expected an error", lines)
+		}
+	}
+}
+
+func TestReadGeoJSON(t *testing.T) {
+	const geoJSON = `{"type": "FeatureCollection", "features": [
+		{"type": "Feature", "properties": {"name": "building", "attenuation_db": 6},
+		 "geometry": {"type": "Polygon", "coordinates": [[[0, 0], [10, 0], [10, 10], [0, 10], [0, 0]]]}},
+		{"type": "Feature", "properties": {},
+		 "geometry": {"type": "LineString", "coordinates": [[20, -5], [20, 5]]}},
+		{"type": "Feature", "properties": {"name": "vault", "opaque": true},
+		 "geometry": {"type": "MultiPolygon", "coordinates": [[[[30, 0], [31, 0], [31, 1], [30, 0]]]]}}
+	]}`
+	obstacles, err := ReadGeoJSON(strings.NewReader(geoJSON), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := func(x, y float64) *squirrel.Position { return &squirrel.Position{X: x, Y: y} }
+	tests := []struct {
+		a, b     *squirrel.Position
+		expected float64
+	}{
+		{at(-5, 5), at(15, 5), 12},              // both walls of the building
+		{at(-5, 4), at(25, 4), 22},              // and the wall, at the default attenuation
+		{at(-5, -20), at(25, -20), 0},           // around everything
+		{at(25, 0.5), at(35, 0.5), math.Inf(1)}, // into the vault
+	}
+	for _, test := range tests {
+		if got := obstacles.Attenuation(test.a, test.b); got != test.expected {
+			t.Errorf("%v to %v: attenuation is %v, expected %v", *test.a, *test.b, got, test.expected)
+		}
+		if los := obstacles.LineOfSight(test.a, test.b); los != (test.expected == 0) {
+			t.Errorf("%v to %v: line of sight is %v", *test.a, *test.b, los)
+		}
+	}
+
+	for _, invalid := range []string{
+		`{"type": "Feature"}`,
+		`{"type": "FeatureCollection", "features": [{"geometry": {"type": "Point", "coordinates": [0, 0]}}]}`,
+		`{"type": "FeatureCollection", "features": [{"geometry": {"type": "LineString", "coordinates": [[0, 0]]}}]}`,
+	} {
+		if _, err := ReadGeoJSON(strings.NewReader(invalid), 10); err == nil {
+			t.Errorf("%s: expected an error", invalid)
+		}
+	}
+}
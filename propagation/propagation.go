@@ -0,0 +1,133 @@
+// Package propagation has models of radio propagation that Septembers can use
+// to decide upon frames based on positions of nodes.
+package propagation
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+
+	"github.com/squirrel-land/squirrel"
+)
+
+// SpeedOfLight is in meters per second.
+const SpeedOfLight = 299792458.0
+
+// PathLoss models attenuation of a radio signal on its way from a transmitter
+// to a receiver.
+type PathLoss interface {
+
+	// Loss returns the path loss(in dB) from a transmitter at tx to a receiver
+	// at rx.
+	Loss(tx, rx *squirrel.Position) float64
+}
+
+// Distance returns the Euclidean distance between a and b, in meters.
+func Distance(a, b *squirrel.Position) float64 {
+	return math.Sqrt(math.Pow(a.X-b.X, 2) + math.Pow(a.Y-b.Y, 2) + math.Pow(a.Height-b.Height, 2))
+}
+
+// Wavelength returns the wavelength(in meters) of frequency(in Hz).
+func Wavelength(frequency float64) float64 {
+	return SpeedOfLight / frequency
+}
+
+// FreeSpace is the Friis free space model, for line of sight without
+// reflections.
+type FreeSpace struct {
+	// Frequency is in Hz.
+	Frequency float64
+}
+
+func (f *FreeSpace) Loss(tx, rx *squirrel.Position) float64 {
+	return freeSpaceLoss(Distance(tx, rx), f.Frequency)
+}
+
+func freeSpaceLoss(distance, frequency float64) float64 {
+	loss := 20 * math.Log10(4*math.Pi*distance/Wavelength(frequency))
+	// Friis doesn't hold in the near field; there's no gain from getting
+	// closer than where loss is 0.
+	if loss < 0 || math.IsNaN(loss) {
+		return 0
+	}
+	return loss
+}
+
+// TwoRayGround adds the ray reflected by flat ground to the direct one. It's
+// the free space model up to the crossover distance, beyond which loss grows
+// with the fourth power of distance and depends on antenna heights
+// (Position.Height) rather than frequency. If either height is not positive,
+// it's the free space model.
+type TwoRayGround struct {
+	// Frequency is in Hz.
+	Frequency float64
+}
+
+func (t *TwoRayGround) Loss(tx, rx *squirrel.Position) float64 {
+	d := Distance(tx, rx)
+	if tx.Height <= 0 || rx.Height <= 0 {
+		return freeSpaceLoss(d, t.Frequency)
+	}
+	crossover := 4 * math.Pi * tx.Height * rx.Height / Wavelength(t.Frequency)
+	if d < crossover {
+		return freeSpaceLoss(d, t.Frequency)
+	}
+	return 40*math.Log10(d) - 20*math.Log10(tx.Height) - 20*math.Log10(rx.Height)
+}
+
+// LogDistance is the log-distance model: loss grows by 10*Exponent dB per
+// decade of distance beyond ReferenceDistance, where it's ReferenceLoss.
+// Exponent is 2 in free space, and typically 2.7 to 3.5 in urban areas and 4
+// to 6 indoors with obstructions.
+type LogDistance struct {
+	// ReferenceDistance is in meters.
+	ReferenceDistance float64
+
+	// ReferenceLoss is in dB.
+	ReferenceLoss float64
+
+	Exponent float64
+}
+
+// NewLogDistance returns a LogDistance model whose reference loss is that of
+// free space at referenceDistance(in meters) for frequency(in Hz).
+func NewLogDistance(frequency, referenceDistance, exponent float64) *LogDistance {
+	return &LogDistance{
+		ReferenceDistance: referenceDistance,
+		ReferenceLoss:     freeSpaceLoss(referenceDistance, frequency),
+		Exponent:          exponent,
+	}
+}
+
+func (l *LogDistance) Loss(tx, rx *squirrel.Position) float64 {
+	d := Distance(tx, rx)
+	if d <= l.ReferenceDistance {
+		return l.ReferenceLoss
+	}
+	return l.ReferenceLoss + 10*l.Exponent*math.Log10(d/l.ReferenceDistance)
+}
+
+// LogNormalShadowing adds to a PathLoss a zero-mean Gaussian variation(in dB)
+// with standard deviation Sigma, modeling obstacles that are not modeled
+// otherwise. A new variation is drawn for every call, so that the same link
+// can fade in and out from frame to frame.
+type LogNormalShadowing struct {
+	PathLoss
+	Sigma float64
+
+	rng *rand.Rand
+	mu  sync.Mutex
+}
+
+// NewLogNormalShadowing returns a LogNormalShadowing on top of pathLoss, with
+// variations drawn from a source seeded with seed.
+func NewLogNormalShadowing(pathLoss PathLoss, sigma float64, seed int64) *LogNormalShadowing {
+	return &LogNormalShadowing{PathLoss: pathLoss, Sigma: sigma, rng: rand.New(rand.NewSource(seed))}
+}
+
+func (s *LogNormalShadowing) Loss(tx, rx *squirrel.Position) float64 {
+	s.mu.Lock()
+	x := s.rng.NormFloat64()
+	s.mu.Unlock()
+	return s.PathLoss.Loss(tx, rx) + x*s.Sigma
+}
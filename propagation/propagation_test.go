@@ -0,0 +1,73 @@
+package propagation
+
+import (
+	"math"
+	"testing"
+
+	"github.com/squirrel-land/squirrel"
+)
+
+// friis is free space path loss(in dB) in its usual form, in terms of
+// distance(in meters) and frequency(in Hz).
+func friis(distance, frequency float64) float64 {
+	return 20*math.Log10(distance) + 20*math.Log10(frequency) - 147.5522
+}
+
+func TestPathLoss(t *testing.T) {
+	const f = 2.412e9
+	at := func(x, height float64) *squirrel.Position { return &squirrel.Position{X: x, Height: height} }
+	tests := []struct {
+		name     string
+		model    PathLoss
+		tx, rx   *squirrel.Position
+		expected float64
+	}{
+		{"free space", &FreeSpace{Frequency: f}, at(0, 0), at(100, 0), friis(100, f)},
+		{"free space, 3D", &FreeSpace{Frequency: f}, at(0, 0), &squirrel.Position{X: 30, Y: 40, Height: 120}, friis(130, f)},
+		{"free space, near field", &FreeSpace{Frequency: f}, at(0, 0), at(0.001, 0), 0},
+		{"free space, same position", &FreeSpace{Frequency: f}, at(5, 0), at(5, 0), 0},
+		// crossover is about 227m for antennas at 1.5m
+		{"two-ray ground, before crossover", &TwoRayGround{Frequency: f}, at(0, 1.5), at(100, 1.5), friis(100, f)},
+		{"two-ray ground, beyond crossover", &TwoRayGround{Frequency: f}, at(0, 1.5), at(1000, 1.5), 120 - 40*math.Log10(1.5)},
+		{"two-ray ground, on the ground", &TwoRayGround{Frequency: f}, at(0, 0), at(1000, 0), friis(1000, f)},
+		{"log-distance", NewLogDistance(f, 1, 3), at(0, 0), at(100, 0), friis(1, f) + 60},
+		{"log-distance, within reference distance", NewLogDistance(f, 10, 3), at(0, 0), at(2, 0), friis(10, f)},
+		{"log-distance, exponent 2 is free space", NewLogDistance(f, 1, 2), at(0, 0), at(250, 0), friis(250, f)},
+	}
+	for _, test := range tests {
+		if got := test.model.Loss(test.tx, test.rx); math.Abs(got-test.expected) > 0.01 {
+			t.Errorf("%s: loss is %.3f dB, expected %.3f dB", test.name, got, test.expected)
+		}
+	}
+}
+
+func TestLogNormalShadowing(t *testing.T) {
+	const (
+		n     = 20000
+		sigma = 4.0
+	)
+	base := NewLogDistance(2.412e9, 1, 3)
+	tx, rx := &squirrel.Position{}, &squirrel.Position{X: 50}
+	mean := base.Loss(tx, rx)
+
+	s := NewLogNormalShadowing(base, sigma, 1)
+	var sum, sumSquares float64
+	for i := 0; i < n; i++ {
+		x := s.Loss(tx, rx) - mean
+		sum += x
+		sumSquares += x * x
+	}
+	if m := sum / n; math.Abs(m) > 5*sigma/math.Sqrt(n) {
+		t.Errorf("mean variation is %.3f dB, expected about 0", m)
+	}
+	if sd := math.Sqrt(sumSquares / n); math.Abs(sd-sigma) > 0.1 {
+		t.Errorf("standard deviation is %.3f dB, expected about %v", sd, sigma)
+	}
+
+	a, b := NewLogNormalShadowing(base, sigma, 7), NewLogNormalShadowing(base, sigma, 7)
+	for i := 0; i < 10; i++ {
+		if la, lb := a.Loss(tx, rx), b.Loss(tx, rx); la != lb {
+			t.Fatalf("same seed gives %v and %v", la, lb)
+		}
+	}
+}
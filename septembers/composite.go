@@ -0,0 +1,360 @@
+// Package septembers has Septembers that are built into squirrel-master.
+package septembers
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/coreos/go-etcd/etcd"
+	"github.com/squirrel-land/squirrel"
+)
+
+// Composite is a September that chains several Septembers, called stages, e.g.
+// a range model, then a loss model, then a rate limiter. A unicast frame is
+// delivered only if every stage approves it, and a broadcast frame only to
+// nodes every stage chooses. Stages are asked in order, and a stage is not
+// asked about a unicast frame an earlier stage dropped, nor about a broadcast
+// frame earlier stages dropped for every node. Otherwise, a stage decides
+// upon a broadcast frame for all nodes it would, including those an earlier
+// stage dropped it for, so stateful stages such as gilbert_elliott see every
+// broadcast frame that goes through them.
+//
+// Stages that implement http.Handler are served under their keys, e.g.
+// /septembers/<channel>/<stage>. BitErrorRater implemented by stages is not
+// used.
+type Composite struct {
+	newSeptember func(name string) (squirrel.SeptemberV2, error)
+
+	stages    []squirrel.SeptemberV2
+	keys      []string     // of Dirs of stages in configuration
+	names     []string     // of Septembers of stages
+	configs   []*etcd.Node // current configurations of stages
+	receivers []squirrel.FeedbackReceiver
+
+	seed   int64
+	seeded bool
+
+	scratches sync.Pool // *compositeScratch
+}
+
+// compositeScratch holds what merging broadcast decisions of stages needs,
+// reused across frames.
+type compositeScratch struct {
+	results []squirrel.Result // decisions of a later stage
+	index   []int             // identity -> 1 + index in decisions of the first stage, or 0
+	decided []bool            // by index in decisions of the first stage
+}
+
+// NewComposite returns a Composite that creates stages with newSeptember.
+func NewComposite(newSeptember func(name string) (squirrel.SeptemberV2, error)) *Composite {
+	return &Composite{newSeptember: newSeptember}
+}
+
+func (c *Composite) ParametersHelp() string {
+	return `Composite chains several Septembers (stages). A frame is delivered only if
+every stage approves it.
+
+  stages : a Dir with a Dir for each stage, in order of their keys, with:
+    september : name of the September of the stage.
+    config    : configuration (a Dir) of the September. [Optional]
+
+A stage isn't asked about a unicast frame an earlier stage dropped. It's asked
+about a broadcast frame unless earlier stages dropped it for every node, and
+then decides for all nodes, including those an earlier stage dropped it for.
+
+Stages that serve their state over HTTP are served at
+/septembers/<channel>/<stage>, and a list of stages at /septembers/<channel>.
+`
+}
+
+// Seed implements squirrel.Seeder. Each stage that implements it is seeded
+// with a seed derived from seed and its position in the chain.
+func (c *Composite) Seed(seed int64) {
+	c.seed = seed
+	c.seeded = true
+}
+
+// stages returns Dirs of stages in conf, in order of their keys.
+func stages(conf *etcd.Node) ([]*etcd.Node, error) {
+	var stagesDir *etcd.Node
+	if conf != nil {
+		for _, node := range conf.Nodes {
+			if node.Dir && path.Base(node.Key) == "stages" {
+				stagesDir = node
+			}
+		}
+	}
+	if stagesDir == nil || len(stagesDir.Nodes) == 0 {
+		return nil, fmt.Errorf("composite September needs at least one stage")
+	}
+	nodes := make([]*etcd.Node, 0, len(stagesDir.Nodes))
+	for _, node := range stagesDir.Nodes {
+		if node.Dir {
+			nodes = append(nodes, node)
+		}
+	}
+	sort.Slice(nodes, func(i, j int) bool { return path.Base(nodes[i].Key) < path.Base(nodes[j].Key) })
+	return nodes, nil
+}
+
+// stage returns name of the September of a stage, and its configuration.
+func stage(node *etcd.Node) (name string, config *etcd.Node) {
+	for _, n := range node.Nodes {
+		switch path.Base(n.Key) {
+		case "september":
+			name = n.Value
+		case "config":
+			config = n
+		}
+	}
+	return
+}
+
+func (c *Composite) Configure(conf *etcd.Node) error {
+	nodes, err := stages(conf)
+	if err != nil {
+		return err
+	}
+	var (
+		septembers = make([]squirrel.SeptemberV2, 0, len(nodes))
+		keys       = make([]string, 0, len(nodes))
+		names      = make([]string, 0, len(nodes))
+		configs    = make([]*etcd.Node, 0, len(nodes))
+		receivers  []squirrel.FeedbackReceiver
+	)
+	for i, node := range nodes {
+		name, config := stage(node)
+		if name == "" {
+			return fmt.Errorf("stage %s has no september", path.Base(node.Key))
+		}
+		s, err := c.newSeptember(name)
+		if err != nil {
+			return fmt.Errorf("stage %s: %v", path.Base(node.Key), err)
+		}
+		if seeder, ok := squirrel.Implementation(s).(squirrel.Seeder); ok && c.seeded {
+			seeder.Seed(c.seed*31 + int64(i))
+		}
+		if err = s.Configure(config); err != nil {
+			return fmt.Errorf("stage %s (%s): %v\n%s", path.Base(node.Key), name, err, s.ParametersHelp())
+		}
+		septembers = append(septembers, s)
+		keys = append(keys, path.Base(node.Key))
+		names = append(names, name)
+		configs = append(configs, config)
+		if receiver, ok := squirrel.Implementation(s).(squirrel.FeedbackReceiver); ok {
+			receivers = append(receivers, receiver)
+		}
+	}
+	c.stages, c.keys, c.names, c.configs, c.receivers = septembers, keys, names, configs, receivers
+	return nil
+}
+
+// Reconfigure implements squirrel.Reconfigurer. Stages can't be added,
+// removed or replaced at runtime, but those that implement
+// squirrel.Reconfigurer are reconfigured, in order. If one fails, earlier ones
+// are reconfigured back to their current configuration, so that either every
+// stage takes its new configuration or none does.
+func (c *Composite) Reconfigure(conf *etcd.Node) error {
+	nodes, err := stages(conf)
+	if err != nil {
+		return err
+	}
+	if len(nodes) != len(c.stages) {
+		return fmt.Errorf("stages can't be added or removed at runtime")
+	}
+	configs := make([]*etcd.Node, len(nodes))
+	for i, node := range nodes {
+		var name string
+		if name, configs[i] = stage(node); name != c.names[i] {
+			return fmt.Errorf("stage %s: September can't be replaced at runtime", path.Base(node.Key))
+		}
+	}
+	for i, node := range nodes {
+		reconfigurer, ok := squirrel.Implementation(c.stages[i]).(squirrel.Reconfigurer)
+		if !ok {
+			continue
+		}
+		if err = reconfigurer.Reconfigure(configs[i]); err != nil {
+			err = fmt.Errorf("stage %s: %v", path.Base(node.Key), err)
+			if rollbackErr := c.rollback(i); rollbackErr != nil {
+				err = fmt.Errorf("%v; rolling back: %v", err, rollbackErr)
+			}
+			return err
+		}
+	}
+	c.configs = configs
+	return nil
+}
+
+// rollback reconfigures the first n stages back to their current
+// configuration.
+func (c *Composite) rollback(n int) (err error) {
+	for i := 0; i < n; i++ {
+		if reconfigurer, ok := squirrel.Implementation(c.stages[i]).(squirrel.Reconfigurer); ok {
+			if e := reconfigurer.Reconfigure(c.configs[i]); e != nil && err == nil {
+				err = fmt.Errorf("stage %d: %v", i, e)
+			}
+		}
+	}
+	return
+}
+
+// SetClock implements squirrel.ClockUser.
+func (c *Composite) SetClock(clock squirrel.Clock) {
+	for _, stage := range c.stages {
+		if user, ok := squirrel.Implementation(stage).(squirrel.ClockUser); ok {
+			user.SetClock(clock)
+		}
+	}
+}
+
+// SetNodeDirectory implements squirrel.NodeDirectoryUser.
+func (c *Composite) SetNodeDirectory(directory squirrel.NodeDirectory) {
+	for _, stage := range c.stages {
+		if user, ok := squirrel.Implementation(stage).(squirrel.NodeDirectoryUser); ok {
+			user.SetNodeDirectory(directory)
+		}
+	}
+}
+
+func (c *Composite) Initialize(positionManager squirrel.PositionManager) {
+	for _, stage := range c.stages {
+		stage.Initialize(positionManager)
+	}
+}
+
+// Feedback implements squirrel.FeedbackReceiver.
+func (c *Composite) Feedback(feedback *squirrel.Feedback) {
+	for _, receiver := range c.receivers {
+		receiver.Feedback(feedback)
+	}
+}
+
+type compositeStageStatus struct {
+	Stage     string `json:"stage"`
+	September string `json:"september"`
+	Served    bool   `json:"served"`
+}
+
+// ServeHTTP implements http.Handler. A request for /<stage>, relative to where
+// Composite is served, is served by the stage, if it implements http.Handler,
+// with /<stage> stripped. Anything else is served a list of stages.
+func (c *Composite) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	key := strings.TrimPrefix(req.URL.Path, "/")
+	if i := strings.IndexByte(key, '/'); i >= 0 {
+		key = key[:i]
+	}
+	if key != "" {
+		for i, k := range c.keys {
+			if k != key {
+				continue
+			}
+			if handler, ok := squirrel.Implementation(c.stages[i]).(http.Handler); ok {
+				http.StripPrefix("/"+key, handler).ServeHTTP(w, req)
+				return
+			}
+		}
+		http.NotFound(w, req)
+		return
+	}
+	ret := make([]compositeStageStatus, len(c.stages))
+	for i, stage := range c.stages {
+		_, served := squirrel.Implementation(stage).(http.Handler)
+		ret[i] = compositeStageStatus{Stage: c.keys[i], September: c.names[i], Served: served}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(ret)
+}
+
+// merge merges r, from a later stage, into result for the same node.
+func merge(result *squirrel.Result, r *squirrel.Result) {
+	result.Retries += r.Retries
+	if math.IsNaN(result.SINR) {
+		result.SINR = r.SINR
+	}
+	if !r.Delivered && result.Delivered {
+		result.Delivered = false
+		result.Reason = r.Reason
+	}
+}
+
+func (c *Composite) SendUnicast(source int, destination int, frame *squirrel.Frame) squirrel.Result {
+	result := c.stages[0].SendUnicast(source, destination, frame)
+	for _, stage := range c.stages[1:] {
+		if !result.Delivered {
+			break
+		}
+		r := stage.SendUnicast(source, destination, frame)
+		merge(&result, &r)
+	}
+	return result
+}
+
+func (c *Composite) SendBroadcast(source int, frame *squirrel.Frame, underlying []squirrel.Result) []squirrel.Result {
+	results := c.stages[0].SendBroadcast(source, frame, underlying)
+	if len(c.stages) == 1 {
+		return results
+	}
+	s, _ := c.scratches.Get().(*compositeScratch)
+	if s == nil {
+		s = new(compositeScratch)
+	}
+	if len(s.results) < len(underlying) {
+		s.results = make([]squirrel.Result, len(underlying))
+	}
+	if cap(s.decided) < len(results) {
+		s.decided = make([]bool, len(results))
+	}
+	decided := s.decided[:len(results)]
+	for i := range results {
+		if d := results[i].Destination; d >= len(s.index) {
+			s.index = append(s.index, make([]int, d+1-len(s.index))...)
+		}
+		s.index[results[i].Destination] = i + 1
+	}
+	for _, stage := range c.stages[1:] {
+		if !anyDelivered(results) {
+			break
+		}
+		for i := range decided {
+			decided[i] = false
+		}
+		for _, r := range stage.SendBroadcast(source, frame, s.results) {
+			if r.Destination < 0 || r.Destination >= len(s.index) || s.index[r.Destination] == 0 {
+				continue
+			}
+			i := s.index[r.Destination] - 1
+			decided[i] = true
+			merge(&results[i], &r)
+		}
+		for i := range results {
+			if results[i].Delivered && !decided[i] {
+				// the stage didn't choose the node
+				results[i].Delivered = false
+				results[i].Reason = squirrel.DroppedUnknown
+			}
+		}
+	}
+	for i := range results {
+		s.index[results[i].Destination] = 0
+	}
+	c.scratches.Put(s)
+	return results
+}
+
+func anyDelivered(results []squirrel.Result) bool {
+	for i := range results {
+		if results[i].Delivered {
+			return true
+		}
+	}
+	return false
+}
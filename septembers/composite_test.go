@@ -0,0 +1,220 @@
+package septembers
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/coreos/go-etcd/etcd"
+	"github.com/squirrel-land/squirrel"
+)
+
+// linkMatrixStages returns configuration of a Composite of LinkMatrix stages,
+// with default_loss of each.
+func linkMatrixStages(defaultLosses ...string) *etcd.Node {
+	stages := &etcd.Node{Key: "/c/stages", Dir: true}
+	for i, loss := range defaultLosses {
+		key := "/c/stages/" + string(rune('a'+i))
+		stages.Nodes = append(stages.Nodes, &etcd.Node{Key: key, Dir: true, Nodes: []*etcd.Node{
+			{Key: key + "/september", Value: "link_matrix"},
+			{Key: key + "/config", Dir: true, Nodes: []*etcd.Node{{Key: key + "/config/default_loss", Value: loss}}},
+		}})
+	}
+	return &etcd.Node{Key: "/c", Dir: true, Nodes: []*etcd.Node{stages}}
+}
+
+func TestCompositeReconfigure(t *testing.T) {
+	c := NewComposite(func(name string) (squirrel.SeptemberV2, error) { return NewLinkMatrix(), nil })
+	if err := c.Configure(linkMatrixStages("0.1", "0.2")); err != nil {
+		t.Fatal(err)
+	}
+	losses := func() (ret []float64) {
+		for _, stage := range c.stages {
+			ret = append(ret, stage.(*LinkMatrix).matrix.Load().(*linkMatrix).defaultLoss)
+		}
+		return
+	}
+
+	if err := c.Reconfigure(linkMatrixStages("0.3", "0.4")); err != nil {
+		t.Fatal(err)
+	}
+	if got := losses(); got[0] != 0.3 || got[1] != 0.4 {
+		t.Errorf("default losses are %v, expected [0.3 0.4]", got)
+	}
+
+	// the first stage is valid, but must not be applied as the second isn't
+	if err := c.Reconfigure(linkMatrixStages("0.5", "x")); err == nil {
+		t.Error("expected an error")
+	}
+	if got := losses(); got[0] != 0.3 || got[1] != 0.4 {
+		t.Errorf("default losses are %v after a failed reconfiguration, expected [0.3 0.4]", got)
+	}
+
+	if err := c.Reconfigure(linkMatrixStages("0.5")); err == nil {
+		t.Error("expected an error removing a stage")
+	}
+}
+
+// fixedStage decides upon broadcast frames as results say, and counts how
+// many it's asked about.
+type fixedStage struct {
+	results []squirrel.Result
+	asked   int
+}
+
+func (s *fixedStage) ParametersHelp() string                              { return "" }
+func (s *fixedStage) Configure(*etcd.Node) error                          { return nil }
+func (s *fixedStage) Initialize(positionManager squirrel.PositionManager) {}
+
+func (s *fixedStage) SendUnicast(source int, destination int, frame *squirrel.Frame) squirrel.Result {
+	return squirrel.Result{Destination: destination, Delivered: true, SINR: math.NaN()}
+}
+
+func (s *fixedStage) SendBroadcast(source int, frame *squirrel.Frame, underlying []squirrel.Result) []squirrel.Result {
+	s.asked++
+	return append(underlying[:0], s.results...)
+}
+
+// fixedComposite returns a Composite of stages.
+func fixedComposite(t *testing.T, stages ...*fixedStage) *Composite {
+	names := make([]string, len(stages))
+	for i := range stages {
+		names[i] = "fixed"
+	}
+	next := 0
+	c := NewComposite(func(name string) (squirrel.SeptemberV2, error) {
+		next++
+		return stages[next-1], nil
+	})
+	if err := c.Configure(compositeStages(names...)); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// compositeStages returns configuration of a Composite of stages with
+// Septembers of names, and no configuration of their own.
+func compositeStages(names ...string) *etcd.Node {
+	stages := &etcd.Node{Key: "/c/stages", Dir: true}
+	for i, name := range names {
+		key := "/c/stages/" + string(rune('a'+i))
+		stages.Nodes = append(stages.Nodes, &etcd.Node{Key: key, Dir: true, Nodes: []*etcd.Node{
+			{Key: key + "/september", Value: name},
+		}})
+	}
+	return &etcd.Node{Key: "/c", Dir: true, Nodes: []*etcd.Node{stages}}
+}
+
+func TestCompositeSendBroadcast(t *testing.T) {
+	nan := math.NaN()
+	first := &fixedStage{results: []squirrel.Result{
+		{Destination: 1, Delivered: true, Retries: 1, SINR: nan},
+		{Destination: 2, Delivered: true, SINR: 10},
+		{Destination: 3, Reason: squirrel.DroppedCollision, SINR: nan},
+		{Destination: 4, Delivered: true, SINR: nan},
+	}}
+	second := &fixedStage{results: []squirrel.Result{
+		{Destination: 5, Delivered: true, SINR: nan}, // not chosen by the first stage
+		{Destination: 3, Delivered: true, Retries: 1, SINR: nan},
+		{Destination: 2, Reason: squirrel.DroppedRetryLimit, Retries: 4, SINR: 20},
+		{Destination: 1, Delivered: true, Retries: 2, SINR: 5},
+	}}
+	c := fixedComposite(t, first, second)
+	expected := map[int]squirrel.Result{
+		1: {Destination: 1, Delivered: true, Retries: 3, SINR: 5},
+		2: {Destination: 2, Reason: squirrel.DroppedRetryLimit, Retries: 4, SINR: 10},
+		3: {Destination: 3, Reason: squirrel.DroppedCollision, Retries: 1, SINR: nan},
+		4: {Destination: 4, Reason: squirrel.DroppedUnknown, SINR: nan}, // not chosen by the second stage
+	}
+	underlying := make([]squirrel.Result, 8)
+	// again, to check that nothing is left behind for the next frame
+	for i := 0; i < 2; i++ {
+		results := c.SendBroadcast(0, nil, underlying)
+		if len(results) != len(expected) {
+			t.Fatalf("got %d results, expected %d", len(results), len(expected))
+		}
+		for _, r := range results {
+			e := expected[r.Destination]
+			if r.Delivered != e.Delivered || r.Reason != e.Reason || r.Retries != e.Retries ||
+				!(r.SINR == e.SINR || math.IsNaN(r.SINR) && math.IsNaN(e.SINR)) {
+				t.Errorf("got %+v, expected %+v", r, e)
+			}
+		}
+	}
+}
+
+func TestCompositeSendBroadcastDroppedForAll(t *testing.T) {
+	first := &fixedStage{results: []squirrel.Result{{Destination: 1, Reason: squirrel.DroppedOutOfRange}}}
+	second := &fixedStage{results: []squirrel.Result{{Destination: 1, Delivered: true}}}
+	c := fixedComposite(t, first, second)
+	results := c.SendBroadcast(0, nil, make([]squirrel.Result, 4))
+	if len(results) != 1 || results[0].Delivered || results[0].Reason != squirrel.DroppedOutOfRange {
+		t.Errorf("got %+v", results)
+	}
+	if second.asked != 0 {
+		t.Error("a later stage is asked about a frame dropped for every node")
+	}
+}
+
+func TestCompositeConfigure(t *testing.T) {
+	c := NewComposite(func(name string) (squirrel.SeptemberV2, error) { return NewLinkMatrix(), nil })
+	if err := c.Configure(linkMatrixStages("0.1", "0.2")); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Configure(linkMatrixStages("0.3", "0.4", "0.5")); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.stages) != 3 || len(c.names) != 3 || len(c.configs) != 3 {
+		t.Errorf("got %d stages, expected 3", len(c.stages))
+	}
+
+	stages := c.stages
+	if err := c.Configure(linkMatrixStages("0.6", "x")); err == nil {
+		t.Error("expected an error")
+	}
+	if len(c.stages) != 3 || c.stages[0] != stages[0] {
+		t.Error("a failed configuration replaced stages")
+	}
+}
+
+func TestCompositeServeHTTP(t *testing.T) {
+	c := NewComposite(func(name string) (squirrel.SeptemberV2, error) {
+		if name == "gilbert_elliott" {
+			return NewGilbertElliott(), nil
+		}
+		return NewLinkMatrix(), nil
+	})
+	conf := compositeStages("link_matrix", "gilbert_elliott")
+	b := conf.Nodes[0].Nodes[1]
+	bConfig := config("p_good_to_bad", "0", "p_bad_to_good", "1")
+	bConfig.Key = b.Key + "/config"
+	b.Nodes = append(b.Nodes, bConfig)
+	if err := c.Configure(conf); err != nil {
+		t.Fatal(err)
+	}
+
+	serve := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c.ServeHTTP(w, httptest.NewRequest("GET", "http://master"+path, nil))
+		return w
+	}
+	var stages []compositeStageStatus
+	if err := json.Unmarshal(serve("/").Body.Bytes(), &stages); err != nil {
+		t.Fatal(err)
+	}
+	expected := []compositeStageStatus{{"a", "link_matrix", false}, {"b", "gilbert_elliott", true}}
+	if !reflect.DeepEqual(stages, expected) {
+		t.Errorf("got stages %+v, expected %+v", stages, expected)
+	}
+	if w := serve("/b"); w.Code != http.StatusOK || w.Body.String() != "[]\n" {
+		t.Errorf("stage b is served %d %q", w.Code, w.Body.String())
+	}
+	for _, path := range []string{"/a", "/c", "/bb"} {
+		if w := serve(path); w.Code != http.StatusNotFound {
+			t.Errorf("%s is served %d, expected not found", path, w.Code)
+		}
+	}
+}
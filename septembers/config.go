@@ -0,0 +1,145 @@
+package septembers
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/coreos/go-etcd/etcd"
+	"github.com/squirrel-land/squirrel"
+)
+
+// values returns values directly under conf, by the last element of their
+// keys.
+func values(conf *etcd.Node) map[string]string {
+	ret := make(map[string]string)
+	if conf == nil {
+		return ret
+	}
+	for _, node := range conf.Nodes {
+		if !node.Dir {
+			ret[path.Base(node.Key)] = node.Value
+		}
+	}
+	return ret
+}
+
+// parseFloats sets each of params, by key, to the value of the same key in
+// vals, if there's one.
+func parseFloats(vals map[string]string, params map[string]*float64) error {
+	for key, p := range params {
+		v, ok := vals[key]
+		if !ok {
+			continue
+		}
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return fmt.Errorf("%s: %v", key, err)
+		}
+		*p = f
+	}
+	return nil
+}
+
+// enabledNodes keeps a copy of identities of enabled nodes of a
+// PositionManager, so that Septembers don't need to ask for it for every
+// broadcast frame.
+type enabledNodes struct {
+	v atomic.Value // []int
+
+	positionManager squirrel.PositionManager
+	onChange        func(enabled []int)
+	mu              sync.Mutex // serializes set
+}
+
+// track starts tracking enabled nodes of positionManager. If onChange is not
+// nil, it is called with them right away, and then from a routine of its own
+// each time they change. Changes that happen while onChange runs are
+// coalesced into one call, so that a slow onChange doesn't hold up
+// positionManager.
+func (e *enabledNodes) track(positionManager squirrel.PositionManager, onChange func(enabled []int)) {
+	e.positionManager, e.onChange = positionManager, onChange
+	changed := make(chan []int, 16)
+	positionManager.RegisterEnabledChanged(changed)
+	e.set(positionManager.Enabled())
+	go func() {
+		for enabled := range changed {
+			// skip to the latest change; this is the only receiver
+			for len(changed) > 0 {
+				enabled = <-changed
+			}
+			e.set(enabled)
+		}
+	}()
+}
+
+func (e *enabledNodes) set(enabled []int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.v.Store(enabled)
+	if e.onChange != nil {
+		e.onChange(enabled)
+	}
+}
+
+func (e *enabledNodes) get() []int {
+	enabled, _ := e.v.Load().([]int)
+	return enabled
+}
+
+// has returns whether identity is enabled. A node can send frames before the
+// change that enabled it reaches the tracking routine, so if positionManager
+// has identity enabled but the copy doesn't yet, the copy is refreshed, and
+// onChange called, right away.
+func (e *enabledNodes) has(identity int) bool {
+	for _, id := range e.get() {
+		if id == identity {
+			return true
+		}
+	}
+	if e.positionManager == nil || !e.positionManager.IsEnabled(identity) {
+		return false
+	}
+	e.set(e.positionManager.Enabled())
+	return true
+}
+
+// nodeLink is a directed link, by how configuration refers to its nodes.
+type nodeLink struct {
+	source      string
+	destination string
+}
+
+// nodes resolves how configuration of a September refers to nodes into
+// identities. A node is referred to by the name it joined with, its hardware
+// address, or its identity. Septembers embed it to implement
+// squirrel.NodeDirectoryUser.
+type nodes struct {
+	directory squirrel.NodeDirectory
+}
+
+// SetNodeDirectory implements squirrel.NodeDirectoryUser.
+func (n *nodes) SetNodeDirectory(directory squirrel.NodeDirectory) {
+	n.directory = directory
+}
+
+// identity returns the identity of the joined node that node refers to.
+func (n *nodes) identity(node string) (identity int, ok bool) {
+	if n.directory != nil {
+		if identity, ok = n.directory.Identity(node); ok {
+			return
+		}
+	}
+	identity, err := strconv.Atoi(node)
+	return identity, err == nil
+}
+
+// identities returns identities of source and destination of link.
+func (n *nodes) identities(link nodeLink) (ids [2]int, ok bool) {
+	var ok1, ok2 bool
+	ids[0], ok1 = n.identity(link.source)
+	ids[1], ok2 = n.identity(link.destination)
+	return ids, ok1 && ok2
+}
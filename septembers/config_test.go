@@ -0,0 +1,121 @@
+package septembers
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/coreos/go-etcd/etcd"
+	"github.com/squirrel-land/squirrel"
+)
+
+// silentPositionManager never tells about changes of enabled nodes, as if
+// they were yet to reach whoever tracks them.
+type silentPositionManager struct {
+	*fakePositionManager
+}
+
+func (silentPositionManager) RegisterEnabledChanged(channel chan<- []int) {}
+
+// fakeDirectory is a NodeDirectory of names nodes joined with.
+type fakeDirectory struct {
+	mu    sync.Mutex
+	names map[string]int
+}
+
+func (d *fakeDirectory) Identity(node string) (int, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	identity, ok := d.names[node]
+	return identity, ok
+}
+
+func (d *fakeDirectory) join(node string, identity int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.names == nil {
+		d.names = make(map[string]int)
+	}
+	d.names[node] = identity
+}
+
+func TestEnabledNodesHas(t *testing.T) {
+	positionManager := silentPositionManager{newFakePositionManager()}
+	positionManager.Set(1, 0, 0, 0)
+	var (
+		e       enabledNodes
+		changes [][]int
+	)
+	e.track(positionManager, func(enabled []int) { changes = append(changes, enabled) })
+
+	positionManager.Set(2, 0, 0, 0)
+	if !e.has(1) || !e.has(2) {
+		t.Error("enabled nodes are not known")
+	}
+	if e.has(3) {
+		t.Error("a node that is not enabled is known")
+	}
+	if expected := [][]int{{1}, {1, 2}}; !reflect.DeepEqual(changes, expected) {
+		t.Errorf("onChange is called with %v, expected %v", changes, expected)
+	}
+}
+
+// TestResolveOnFirstFrame has nodes send frames before the change that
+// enabled them is tracked, which must already be decided upon with
+// configuration that refers to them.
+func TestResolveOnFirstFrame(t *testing.T) {
+	tracePath := filepath.Join(t.TempDir(), "trace.csv")
+	if err := os.WriteFile(tracePath, []byte("time,source,destination,loss\n0,node-a,node-b,0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	links := &etcd.Node{Key: "/conf/links", Dir: true, Nodes: []*etcd.Node{
+		{Key: "/conf/links/node-a", Dir: true, Nodes: []*etcd.Node{{Key: "/conf/links/node-a/node-b", Value: "up"}}},
+	}}
+	tests := []struct {
+		name      string
+		september squirrel.SeptemberV2
+		conf      *etcd.Node
+	}{
+		{"link_matrix", NewLinkMatrix(), &etcd.Node{Key: "/conf", Dir: true, Nodes: []*etcd.Node{
+			{Key: "/conf/default_loss", Value: "1"}, links,
+		}}},
+		{"trace", NewTrace(), &etcd.Node{Key: "/conf", Dir: true, Nodes: []*etcd.Node{
+			{Key: "/conf/path", Value: tracePath},
+		}}},
+		{"gilbert_elliott", NewGilbertElliott(), &etcd.Node{Key: "/conf", Dir: true, Nodes: []*etcd.Node{
+			{Key: "/conf/p_good_to_bad", Value: "0"},
+			{Key: "/conf/p_bad_to_good", Value: "1"},
+			{Key: "/conf/loss_good", Value: "1"},
+			{Key: "/conf/links", Dir: true, Nodes: []*etcd.Node{
+				{Key: "/conf/links/ab", Dir: true, Nodes: []*etcd.Node{
+					{Key: "/conf/links/ab/source", Value: "node-a"},
+					{Key: "/conf/links/ab/destination", Value: "node-b"},
+					{Key: "/conf/links/ab/loss_good", Value: "0"},
+				}},
+			}},
+		}}},
+	}
+	for _, test := range tests {
+		directory := new(fakeDirectory)
+		test.september.(squirrel.NodeDirectoryUser).SetNodeDirectory(directory)
+		if err := test.september.Configure(test.conf); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		positionManager := silentPositionManager{newFakePositionManager()}
+		test.september.Initialize(positionManager)
+
+		directory.join("node-a", 1)
+		directory.join("node-b", 2)
+		positionManager.Set(1, 0, 0, 0)
+		positionManager.Set(2, 0, 0, 0)
+		if r := test.september.SendUnicast(1, 2, nil); !r.Delivered {
+			t.Errorf("%s: first unicast frame is decided upon without configuration of its link", test.name)
+		}
+		results := test.september.SendBroadcast(1, nil, make([]squirrel.Result, 4))
+		if len(results) != 1 || !results[0].Delivered {
+			t.Errorf("%s: first broadcast frame got %+v", test.name, results)
+		}
+	}
+}
@@ -0,0 +1,263 @@
+package septembers
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"net/http"
+	"path"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/coreos/go-etcd/etcd"
+	"github.com/squirrel-land/squirrel"
+)
+
+// GilbertElliott is a September with bursty loss. Each directed link is a
+// two-state Markov chain: in the good state frames are lost with a low
+// probability, and in the bad state with a high one. The chain makes a
+// transition after every frame on the link. Links start in the good state.
+//
+// GilbertElliott implements http.Handler, serving the current state of links
+// as JSON.
+type GilbertElliott struct {
+	nodes
+	global    geParams
+	overrides map[nodeLink]*geParams
+
+	seed   int64
+	seeded bool
+
+	// resolved is re-resolved from overrides whenever nodes are enabled or
+	// disabled.
+	resolved atomic.Value // *resolvedGE
+	enabled  enabledNodes
+
+	rng    *rand.Rand
+	states map[[2]int]*geState // by identities of source and destination
+	mu     sync.Mutex          // for rng and states
+}
+
+type geParams struct {
+	GoodToBad float64 `json:"p_good_to_bad"`
+	BadToGood float64 `json:"p_bad_to_good"`
+	LossGood  float64 `json:"loss_good"`
+	LossBad   float64 `json:"loss_bad"`
+}
+
+func (p *geParams) parse(vals map[string]string) (err error) {
+	if err = parseFloats(vals, map[string]*float64{
+		"p_good_to_bad": &p.GoodToBad,
+		"p_bad_to_good": &p.BadToGood,
+		"loss_good":     &p.LossGood,
+		"loss_bad":      &p.LossBad,
+	}); err != nil {
+		return
+	}
+	for _, v := range []float64{p.GoodToBad, p.BadToGood, p.LossGood, p.LossBad} {
+		if v < 0 || v > 1 {
+			return fmt.Errorf("probabilities should be within [0, 1]")
+		}
+	}
+	return
+}
+
+type geState struct {
+	bad bool
+
+	frames uint64
+	lost   uint64
+}
+
+type resolvedGE struct {
+	enabled []int
+	params  map[[2]int]*geParams // by identities of source and destination
+}
+
+func NewGilbertElliott() *GilbertElliott {
+	return new(GilbertElliott)
+}
+
+func (g *GilbertElliott) ParametersHelp() string {
+	return `GilbertElliott makes loss bursty with a two-state (good and bad) Markov chain
+per directed link, which makes a transition after every frame on the link.
+
+  p_good_to_bad : probability of moving from good state to bad. [Required]
+  p_bad_to_good : probability of moving from bad state to good. [Required]
+  loss_good     : probability that a frame is lost in good state. Default: 0
+  loss_bad      : probability that a frame is lost in bad state. Default: 1
+  links         : a Dir with a Dir for each link with parameters of its own,
+                  with: [Optional]
+    source      : name, hardware address, or identity of the sender.
+    destination : name, hardware address, or identity of the receiver.
+    any of the parameters above, which default to the global ones.
+`
+}
+
+// Seed implements squirrel.Seeder.
+func (g *GilbertElliott) Seed(seed int64) {
+	g.seed = seed
+	g.seeded = true
+}
+
+func (g *GilbertElliott) Configure(conf *etcd.Node) (err error) {
+	vals := values(conf)
+	for _, key := range []string{"p_good_to_bad", "p_bad_to_good"} {
+		if _, ok := vals[key]; !ok {
+			return fmt.Errorf("%s is required", key)
+		}
+	}
+	g.global = geParams{LossBad: 1}
+	if err = g.global.parse(vals); err != nil {
+		return
+	}
+	g.overrides = make(map[nodeLink]*geParams)
+	if conf != nil {
+		for _, node := range conf.Nodes {
+			if !node.Dir || path.Base(node.Key) != "links" {
+				continue
+			}
+			for _, l := range node.Nodes {
+				vals := values(l)
+				link := nodeLink{source: vals["source"], destination: vals["destination"]}
+				if link.source == "" || link.destination == "" {
+					return fmt.Errorf("link %s needs source and destination", path.Base(l.Key))
+				}
+				params := g.global
+				if err = params.parse(vals); err != nil {
+					return fmt.Errorf("link %s: %v", path.Base(l.Key), err)
+				}
+				g.overrides[link] = &params
+			}
+		}
+	}
+	if !g.seeded {
+		g.seed = time.Now().UnixNano()
+	}
+	g.rng = rand.New(rand.NewSource(g.seed))
+	g.states = make(map[[2]int]*geState)
+	return
+}
+
+func (g *GilbertElliott) Initialize(positionManager squirrel.PositionManager) {
+	g.enabled.track(positionManager, g.resolve)
+}
+
+// resolve resolves overrides, and forgets states of links of nodes that are
+// no longer enabled, so that a node that rejoins starts over.
+func (g *GilbertElliott) resolve(enabled []int) {
+	r := &resolvedGE{enabled: enabled, params: make(map[[2]int]*geParams)}
+	for link, params := range g.overrides {
+		if ids, ok := g.identities(link); ok {
+			r.params[ids] = params
+		}
+	}
+	g.resolved.Store(r)
+
+	isEnabled := make(map[int]bool, len(enabled))
+	for _, id := range enabled {
+		isEnabled[id] = true
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for ids := range g.states {
+		if !isEnabled[ids[0]] || !isEnabled[ids[1]] {
+			delete(g.states, ids)
+		}
+	}
+}
+
+// decide decides upon a frame from source to destination, and makes a
+// transition on the link.
+func (g *GilbertElliott) decide(r *resolvedGE, source int, destination int) squirrel.Result {
+	ids := [2]int{source, destination}
+	params, ok := r.params[ids]
+	if !ok {
+		params = &g.global
+	}
+	g.mu.Lock()
+	state, ok := g.states[ids]
+	if !ok {
+		state = new(geState)
+		g.states[ids] = state
+	}
+	loss, transition := params.LossGood, params.GoodToBad
+	if state.bad {
+		loss, transition = params.LossBad, params.BadToGood
+	}
+	lost := g.rng.Float64() < loss
+	if g.rng.Float64() < transition {
+		state.bad = !state.bad
+	}
+	state.frames++
+	if lost {
+		state.lost++
+	}
+	g.mu.Unlock()
+	result := squirrel.Result{Destination: destination, Delivered: !lost, SINR: math.NaN()}
+	if lost {
+		result.Reason = squirrel.DroppedUnknown
+	}
+	return result
+}
+
+func (g *GilbertElliott) SendUnicast(source int, destination int, frame *squirrel.Frame) squirrel.Result {
+	g.enabled.has(source)
+	g.enabled.has(destination)
+	return g.decide(g.resolved.Load().(*resolvedGE), source, destination)
+}
+
+func (g *GilbertElliott) SendBroadcast(source int, frame *squirrel.Frame, underlying []squirrel.Result) []squirrel.Result {
+	g.enabled.has(source)
+	r := g.resolved.Load().(*resolvedGE)
+	results := underlying[:0]
+	for _, id := range r.enabled {
+		if id != source {
+			results = append(results, g.decide(r, source, id))
+		}
+	}
+	return results
+}
+
+type geLinkStatus struct {
+	Source      int    `json:"source"`
+	Destination int    `json:"destination"`
+	State       string `json:"state"`
+	Frames      uint64 `json:"frames"`
+	Lost        uint64 `json:"lost"`
+	geParams
+}
+
+// ServeHTTP serves the state of every link that has seen a frame.
+func (g *GilbertElliott) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	// nil until initialized
+	r, _ := g.resolved.Load().(*resolvedGE)
+	g.mu.Lock()
+	ret := make([]geLinkStatus, 0, len(g.states))
+	for ids, state := range g.states {
+		status := geLinkStatus{Source: ids[0], Destination: ids[1], State: "good", Frames: state.frames, Lost: state.lost, geParams: g.global}
+		if state.bad {
+			status.State = "bad"
+		}
+		if r != nil {
+			if params, ok := r.params[ids]; ok {
+				status.geParams = *params
+			}
+		}
+		ret = append(ret, status)
+	}
+	g.mu.Unlock()
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Source != ret[j].Source {
+			return ret[i].Source < ret[j].Source
+		}
+		return ret[i].Destination < ret[j].Destination
+	})
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(ret)
+}
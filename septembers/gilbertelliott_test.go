@@ -0,0 +1,189 @@
+package septembers
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/coreos/go-etcd/etcd"
+	"github.com/squirrel-land/squirrel"
+)
+
+func TestGEParamsParse(t *testing.T) {
+	global := geParams{LossBad: 1}
+	if err := global.parse(map[string]string{"p_good_to_bad": "0.1", "p_bad_to_good": "0.3"}); err != nil {
+		t.Fatal(err)
+	}
+	if expected := (geParams{GoodToBad: 0.1, BadToGood: 0.3, LossBad: 1}); global != expected {
+		t.Errorf("got %+v, expected %+v", global, expected)
+	}
+
+	// a link inherits what it doesn't set from the global parameters
+	override := global
+	if err := override.parse(map[string]string{"loss_good": "0.05", "p_bad_to_good": "0.5"}); err != nil {
+		t.Fatal(err)
+	}
+	if expected := (geParams{GoodToBad: 0.1, BadToGood: 0.5, LossGood: 0.05, LossBad: 1}); override != expected {
+		t.Errorf("got %+v, expected %+v", override, expected)
+	}
+
+	for _, vals := range []map[string]string{
+		{"p_good_to_bad": "1.5"},
+		{"p_bad_to_good": "-0.1"},
+		{"loss_good": "x"},
+		{"loss_bad": "2"},
+	} {
+		p := global
+		if err := p.parse(vals); err == nil {
+			t.Errorf("%v: expected an error", vals)
+		}
+	}
+}
+
+// newGE returns a seeded GilbertElliott configured with conf, initialized
+// with nodes 1 to 3 enabled.
+func newGE(t *testing.T, conf *etcd.Node) *GilbertElliott {
+	g := NewGilbertElliott()
+	g.Seed(42)
+	if err := g.Configure(conf); err != nil {
+		t.Fatal(err)
+	}
+	positionManager := newFakePositionManager()
+	for id := 1; id <= 3; id++ {
+		positionManager.Set(id, 0, 0, 0)
+	}
+	g.Initialize(positionManager)
+	return g
+}
+
+func TestGEDecideTransitions(t *testing.T) {
+	// the chain flips state after every frame, and loses every frame in the
+	// bad state
+	g := newGE(t, config("p_good_to_bad", "1", "p_bad_to_good", "1", "loss_good", "0", "loss_bad", "1"))
+	for i := 0; i < 6; i++ {
+		// links are independent of each other
+		for _, destination := range []int{2, 3} {
+			r := g.SendUnicast(1, destination, nil)
+			if expected := i%2 == 0; r.Delivered != expected {
+				t.Errorf("frame %d to %d: delivered %v, expected %v", i, destination, r.Delivered, expected)
+			}
+			if !r.Delivered && r.Reason != squirrel.DroppedUnknown {
+				t.Errorf("frame %d to %d is dropped for %v", i, destination, r.Reason)
+			}
+		}
+	}
+}
+
+func TestGEDecideBursty(t *testing.T) {
+	const frames = 20000
+	conf := config("p_good_to_bad", "0.05", "p_bad_to_good", "0.25", "loss_good", "0", "loss_bad", "1")
+	sequence := func() []bool {
+		g := newGE(t, conf)
+		lost := make([]bool, frames)
+		for i := range lost {
+			lost[i] = !g.SendUnicast(1, 2, nil).Delivered
+		}
+		return lost
+	}
+	lost := sequence()
+
+	var losses, bursts int
+	for i, l := range lost {
+		if l {
+			losses++
+			if i == 0 || !lost[i-1] {
+				bursts++
+			}
+		}
+	}
+	// the chain is in the bad state 0.05 / (0.05 + 0.25) of the time, for
+	// 1 / 0.25 frames in a row
+	if rate := float64(losses) / frames; rate < 0.14 || rate > 0.19 {
+		t.Errorf("loss rate is %v, expected about 0.167", rate)
+	}
+	if burst := float64(losses) / float64(bursts); burst < 3.5 || burst > 4.5 {
+		t.Errorf("losses come in bursts of %v, expected about 4", burst)
+	}
+
+	// seeded the same, the chain goes the same way
+	for i, l := range sequence() {
+		if l != lost[i] {
+			t.Fatalf("frame %d is decided upon differently with the same seed", i)
+		}
+	}
+}
+
+func TestGEResolveForgetsStates(t *testing.T) {
+	g := newGE(t, config("p_good_to_bad", "1", "p_bad_to_good", "0", "loss_good", "0", "loss_bad", "1"))
+	if !g.SendUnicast(1, 2, nil).Delivered || g.SendUnicast(1, 2, nil).Delivered {
+		t.Fatal("link isn't stuck in the bad state after a frame")
+	}
+	g.SendUnicast(1, 3, nil)
+
+	// 2 leaves; states of the links of 1 and 3 are kept
+	g.resolve([]int{1, 3})
+	g.mu.Lock()
+	_, kept := g.states[[2]int{1, 3}]
+	_, forgotten := g.states[[2]int{1, 2}]
+	g.mu.Unlock()
+	if !kept || forgotten {
+		t.Errorf("after 2 left, state of link to 3 is kept: %v, and to 2: %v", kept, forgotten)
+	}
+
+	// and starts over when it rejoins
+	g.resolve([]int{1, 2, 3})
+	if !g.SendUnicast(1, 2, nil).Delivered {
+		t.Error("link to a node that rejoined doesn't start in the good state")
+	}
+	if g.SendUnicast(1, 3, nil).Delivered {
+		t.Error("link to a node that stayed starts over")
+	}
+}
+
+func TestGEServeHTTP(t *testing.T) {
+	conf := config("p_good_to_bad", "1", "p_bad_to_good", "0", "loss_good", "0", "loss_bad", "1")
+	conf.Nodes = append(conf.Nodes, &etcd.Node{Key: "/conf/links", Dir: true, Nodes: []*etcd.Node{
+		{Key: "/conf/links/a", Dir: true, Nodes: []*etcd.Node{
+			{Key: "/conf/links/a/source", Value: "1"},
+			{Key: "/conf/links/a/destination", Value: "3"},
+			{Key: "/conf/links/a/p_good_to_bad", Value: "0"},
+		}},
+	}})
+
+	// state isn't there before initialization
+	uninitialized := NewGilbertElliott()
+	if err := uninitialized.Configure(conf); err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	uninitialized.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if body := w.Body.String(); body != "[]\n" {
+		t.Errorf("got %q before initialization, expected []", body)
+	}
+
+	g := newGE(t, conf)
+	g.SendUnicast(1, 2, nil)
+	g.SendUnicast(1, 2, nil)
+	g.SendUnicast(1, 3, nil)
+	w = httptest.NewRecorder()
+	g.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("content type is %q", ct)
+	}
+	var got []geLinkStatus
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	expected := []geLinkStatus{
+		{Source: 1, Destination: 2, State: "bad", Frames: 2, Lost: 1, geParams: geParams{GoodToBad: 1, LossBad: 1}},
+		{Source: 1, Destination: 3, State: "good", Frames: 1, Lost: 0, geParams: geParams{LossBad: 1}},
+	}
+	if len(got) != len(expected) {
+		t.Fatalf("got %+v, expected %+v", got, expected)
+	}
+	for i := range got {
+		if got[i] != expected[i] {
+			t.Errorf("got %+v, expected %+v", got[i], expected[i])
+		}
+	}
+}
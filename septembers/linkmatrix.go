@@ -0,0 +1,189 @@
+package septembers
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"path"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/coreos/go-etcd/etcd"
+	"github.com/squirrel-land/squirrel"
+)
+
+// LinkMatrix is a September driven by an explicit matrix of directed links
+// and their loss probabilities, regardless of positions of nodes. It can be
+// reconfigured at runtime, so that links can be toggled during a run.
+type LinkMatrix struct {
+	nodes
+
+	seed   int64
+	seeded bool
+	rng    *rand.Rand
+	mu     sync.Mutex // for rng
+
+	matrix atomic.Value // *linkMatrix
+
+	// resolved is re-resolved from matrix whenever nodes are enabled or
+	// disabled, or matrix changes.
+	resolved  atomic.Value // *resolvedMatrix
+	enabled   enabledNodes
+	resolveMu sync.Mutex // serializes resolving
+}
+
+type linkMatrix struct {
+	defaultLoss float64
+	links       map[nodeLink]float64 // loss probabilities
+}
+
+type resolvedMatrix struct {
+	enabled     []int
+	defaultLoss float64
+	links       map[[2]int]float64 // by identities of source and destination
+}
+
+func NewLinkMatrix() *LinkMatrix {
+	return new(LinkMatrix)
+}
+
+func (l *LinkMatrix) ParametersHelp() string {
+	return `LinkMatrix delivers frames according to an explicit matrix of directed links,
+regardless of positions of nodes. It's reconfigured when its configuration
+changes.
+
+  default_loss : loss probability of links not in the matrix. Default: 1
+  links        : a Dir with a Dir for each source node, with a value for each
+                 destination node, which is the loss probability of the link
+                 from 0 to 1, or up (0) or down (1). Nodes are referred to by
+                 names, hardware addresses, or identities. E.g.:
+                   links/node-a/node-b = 0.1
+                   links/node-b/node-c = down
+`
+}
+
+// Seed implements squirrel.Seeder.
+func (l *LinkMatrix) Seed(seed int64) {
+	l.seed = seed
+	l.seeded = true
+}
+
+func (l *LinkMatrix) Configure(conf *etcd.Node) (err error) {
+	if !l.seeded {
+		l.seed = time.Now().UnixNano()
+	}
+	l.rng = rand.New(rand.NewSource(l.seed))
+	return l.Reconfigure(conf)
+}
+
+func parseLinkLoss(value string) (loss float64, err error) {
+	switch value {
+	case "up":
+		return 0, nil
+	case "down":
+		return 1, nil
+	}
+	if loss, err = strconv.ParseFloat(value, 64); err == nil && (loss < 0 || loss > 1) {
+		err = fmt.Errorf("loss probability should be within [0, 1]")
+	}
+	return
+}
+
+// Reconfigure implements squirrel.Reconfigurer.
+func (l *LinkMatrix) Reconfigure(conf *etcd.Node) (err error) {
+	m := &linkMatrix{defaultLoss: 1, links: make(map[nodeLink]float64)}
+	if err = parseFloats(values(conf), map[string]*float64{"default_loss": &m.defaultLoss}); err != nil {
+		return
+	}
+	if m.defaultLoss < 0 || m.defaultLoss > 1 {
+		return fmt.Errorf("default_loss should be within [0, 1]")
+	}
+	if conf != nil {
+		for _, node := range conf.Nodes {
+			if !node.Dir || path.Base(node.Key) != "links" {
+				continue
+			}
+			for _, source := range node.Nodes {
+				if !source.Dir {
+					return fmt.Errorf("%s is not a Dir", source.Key)
+				}
+				for _, destination := range source.Nodes {
+					link := nodeLink{source: path.Base(source.Key), destination: path.Base(destination.Key)}
+					if m.links[link], err = parseLinkLoss(destination.Value); err != nil {
+						return fmt.Errorf("%s: %v", destination.Key, err)
+					}
+				}
+			}
+		}
+	}
+	l.matrix.Store(m)
+	l.resolveMu.Lock()
+	defer l.resolveMu.Unlock()
+	if l.resolved.Load() != nil {
+		// already initialized
+		l.resolve()
+	}
+	return
+}
+
+func (l *LinkMatrix) Initialize(positionManager squirrel.PositionManager) {
+	l.enabled.track(positionManager, func([]int) {
+		l.resolveMu.Lock()
+		l.resolve()
+		l.resolveMu.Unlock()
+	})
+}
+
+// resolve should be called with resolveMu locked.
+func (l *LinkMatrix) resolve() {
+	m := l.matrix.Load().(*linkMatrix)
+	r := &resolvedMatrix{enabled: l.enabled.get(), defaultLoss: m.defaultLoss, links: make(map[[2]int]float64)}
+	for link, loss := range m.links {
+		if ids, ok := l.identities(link); ok {
+			r.links[ids] = loss
+		}
+	}
+	l.resolved.Store(r)
+}
+
+func (l *LinkMatrix) decide(r *resolvedMatrix, source int, destination int) squirrel.Result {
+	loss, ok := r.links[[2]int{source, destination}]
+	if !ok {
+		loss = r.defaultLoss
+	}
+	result := squirrel.Result{Destination: destination, SINR: math.NaN()}
+	switch {
+	case loss >= 1:
+		result.Reason = squirrel.DroppedOutOfRange
+	case loss <= 0:
+		result.Delivered = true
+	default:
+		l.mu.Lock()
+		result.Delivered = l.rng.Float64() >= loss
+		l.mu.Unlock()
+		if !result.Delivered {
+			result.Reason = squirrel.DroppedUnknown
+		}
+	}
+	return result
+}
+
+func (l *LinkMatrix) SendUnicast(source int, destination int, frame *squirrel.Frame) squirrel.Result {
+	l.enabled.has(source)
+	l.enabled.has(destination)
+	return l.decide(l.resolved.Load().(*resolvedMatrix), source, destination)
+}
+
+func (l *LinkMatrix) SendBroadcast(source int, frame *squirrel.Frame, underlying []squirrel.Result) []squirrel.Result {
+	l.enabled.has(source)
+	r := l.resolved.Load().(*resolvedMatrix)
+	results := underlying[:0]
+	for _, id := range r.enabled {
+		if id != source {
+			results = append(results, l.decide(r, source, id))
+		}
+	}
+	return results
+}
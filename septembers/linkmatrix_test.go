@@ -0,0 +1,169 @@
+package septembers
+
+import (
+	"testing"
+
+	"github.com/coreos/go-etcd/etcd"
+	"github.com/squirrel-land/squirrel"
+)
+
+func TestParseLinkLoss(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected float64
+		err      bool
+	}{
+		{"up", 0, false},
+		{"down", 1, false},
+		{"0.25", 0.25, false},
+		{"0", 0, false},
+		{"1", 1, false},
+		{"1.5", 0, true},
+		{"-0.1", 0, true},
+		{"Up", 0, true},
+		{"", 0, true},
+	}
+	for _, test := range tests {
+		loss, err := parseLinkLoss(test.value)
+		if (err != nil) != test.err || (err == nil && loss != test.expected) {
+			t.Errorf("%q: got %v (%v), expected %v (error: %v)", test.value, loss, err, test.expected, test.err)
+		}
+	}
+}
+
+// linkMatrixConfig returns configuration of a LinkMatrix with defaultLoss,
+// and links given as source, destination and loss triples.
+func linkMatrixConfig(defaultLoss string, links ...string) *etcd.Node {
+	bySource := make(map[string]*etcd.Node)
+	linksDir := &etcd.Node{Key: "/conf/links", Dir: true}
+	for i := 0; i+2 < len(links); i += 3 {
+		source, ok := bySource[links[i]]
+		if !ok {
+			source = &etcd.Node{Key: "/conf/links/" + links[i], Dir: true}
+			bySource[links[i]] = source
+			linksDir.Nodes = append(linksDir.Nodes, source)
+		}
+		source.Nodes = append(source.Nodes, &etcd.Node{Key: source.Key + "/" + links[i+1], Value: links[i+2]})
+	}
+	conf := config("default_loss", defaultLoss)
+	conf.Nodes = append(conf.Nodes, linksDir)
+	return conf
+}
+
+// delivered returns whether frames from source to destination are delivered,
+// with losses of 0 or 1 only.
+func delivered(l *LinkMatrix, source, destination int) bool {
+	return l.SendUnicast(source, destination, nil).Delivered
+}
+
+func TestLinkMatrixNodeKeys(t *testing.T) {
+	directory := new(fakeDirectory)
+	directory.join("node-a", 1)
+	directory.join("02:00:00:00:00:02", 2)
+	l := NewLinkMatrix()
+	l.SetNodeDirectory(directory)
+	err := l.Configure(linkMatrixConfig("1",
+		"node-a", "02:00:00:00:00:02", "up", // by name and hardware address
+		"3", "node-a", "up", // by identity
+		"node-b", "3", "up", // not joined
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	positionManager := newFakePositionManager()
+	for id := 1; id <= 3; id++ {
+		positionManager.Set(id, 0, 0, 0)
+	}
+	l.Initialize(positionManager)
+
+	tests := []struct {
+		source, destination int
+		expected            bool
+	}{
+		{1, 2, true},
+		{3, 1, true},
+		{2, 1, false}, // links are directed
+		{2, 3, false},
+	}
+	for _, test := range tests {
+		if got := delivered(l, test.source, test.destination); got != test.expected {
+			t.Errorf("%d -> %d: delivered %v, expected %v", test.source, test.destination, got, test.expected)
+		}
+	}
+}
+
+func TestLinkMatrixReconfigure(t *testing.T) {
+	l := NewLinkMatrix()
+	l.Seed(1)
+	if err := l.Configure(linkMatrixConfig("0", "1", "2", "down")); err != nil {
+		t.Fatal(err)
+	}
+	// reconfiguration before initialization is resolved upon it
+	if err := l.Reconfigure(linkMatrixConfig("0", "1", "3", "down")); err != nil {
+		t.Fatal(err)
+	}
+	if l.resolved.Load() != nil {
+		t.Error("configuration is resolved before initialization")
+	}
+	positionManager := newFakePositionManager()
+	for id := 1; id <= 3; id++ {
+		positionManager.Set(id, 0, 0, 0)
+	}
+	l.Initialize(positionManager)
+	if !delivered(l, 1, 2) || delivered(l, 1, 3) {
+		t.Error("configuration before initialization is not the one in effect")
+	}
+
+	// live
+	if err := l.Reconfigure(linkMatrixConfig("1", "1", "3", "up")); err != nil {
+		t.Fatal(err)
+	}
+	if delivered(l, 1, 2) || !delivered(l, 1, 3) {
+		t.Error("reconfiguration is not in effect right away")
+	}
+	results := l.SendBroadcast(1, nil, make([]squirrel.Result, 4))
+	for _, r := range results {
+		if r.Delivered != (r.Destination == 3) {
+			t.Errorf("broadcast frame to %d: delivered %v", r.Destination, r.Delivered)
+		}
+	}
+	if len(results) != 2 {
+		t.Errorf("got %d results of a broadcast frame, expected 2", len(results))
+	}
+
+	// an invalid configuration leaves the current one in effect
+	for _, conf := range []*etcd.Node{
+		linkMatrixConfig("2"),
+		linkMatrixConfig("0", "1", "2", "sometimes"),
+	} {
+		if err := l.Reconfigure(conf); err == nil {
+			t.Error("expected an error")
+		}
+	}
+	if delivered(l, 1, 2) || !delivered(l, 1, 3) {
+		t.Error("a failed reconfiguration changed the matrix")
+	}
+}
+
+func TestLinkMatrixResolvesOnJoin(t *testing.T) {
+	directory := new(fakeDirectory)
+	l := NewLinkMatrix()
+	l.SetNodeDirectory(directory)
+	if err := l.Configure(linkMatrixConfig("1", "node-a", "node-b", "up")); err != nil {
+		t.Fatal(err)
+	}
+	positionManager := newFakePositionManager()
+	l.Initialize(positionManager)
+	if delivered(l, 1, 2) {
+		t.Fatal("link of nodes that haven't joined is up")
+	}
+
+	// nodes join with names before they're enabled, as they do with master
+	directory.join("node-a", 1)
+	directory.join("node-b", 2)
+	positionManager.Set(1, 0, 0, 0)
+	positionManager.Set(2, 0, 0, 0)
+	if !delivered(l, 1, 2) {
+		t.Error("link is not resolved once its nodes joined")
+	}
+}
@@ -0,0 +1,147 @@
+package septembers
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/coreos/go-etcd/etcd"
+	"github.com/squirrel-land/squirrel"
+	"github.com/squirrel-land/squirrel/propagation"
+)
+
+// RxPower is a reference September that delivers a frame if its received
+// power, given by transmit power, antenna gains and a path loss model, is at
+// least the sensitivity of the receiver, optionally with obstacles in the way.
+// It models range only: there's no interference nor contention.
+type RxPower struct {
+	pathLoss    propagation.PathLoss
+	txPower     float64 // dBm
+	antennaGain float64 // dBi, of each antenna
+	sensitivity float64 // dBm
+	noiseFloor  float64 // dBm
+
+	seed   int64
+	seeded bool
+
+	positionManager squirrel.PositionManager
+	enabled         enabledNodes
+}
+
+func NewRxPower() *RxPower {
+	return new(RxPower)
+}
+
+func (r *RxPower) ParametersHelp() string {
+	return `RxPower delivers a frame if its received power is at least the sensitivity
+of the receiver.
+
+  path_loss            : free_space, two_ray_ground, log_distance, or
+                         log_normal_shadowing. Default: log_distance
+  frequency_mhz        : carrier frequency. Default: 2412
+  tx_power_dbm         : transmit power. Default: 20
+  antenna_gain_dbi     : gain of each antenna. Default: 0
+  sensitivity_dbm      : minimum received power to receive a frame. Default: -82
+  noise_floor_dbm      : noise power, for SINR in results. Default: -95
+  path_loss_exponent   : for log_distance and log_normal_shadowing. Default: 3
+  reference_distance_m : for log_distance and log_normal_shadowing. Default: 1
+  shadowing_sigma_db   : for log_normal_shadowing. Default: 4
+  obstacles_path       : path of a GeoJSON file of obstacles that attenuate or
+                         block links, on top of path_loss. [Optional]
+  obstacle_loss_db     : attenuation per crossing of obstacles that don't
+                         have their own attenuation_db. Default: 10
+`
+}
+
+// Seed implements squirrel.Seeder. It seeds log-normal shadowing.
+func (r *RxPower) Seed(seed int64) {
+	r.seed = seed
+	r.seeded = true
+}
+
+func (r *RxPower) Configure(conf *etcd.Node) (err error) {
+	var (
+		frequencyMHz      = 2412.0
+		exponent          = 3.0
+		referenceDistance = 1.0
+		sigma             = 4.0
+		obstacleLoss      = 10.0
+	)
+	r.txPower, r.antennaGain, r.sensitivity, r.noiseFloor = 20, 0, -82, -95
+	vals := values(conf)
+	err = parseFloats(vals, map[string]*float64{
+		"frequency_mhz":        &frequencyMHz,
+		"tx_power_dbm":         &r.txPower,
+		"antenna_gain_dbi":     &r.antennaGain,
+		"sensitivity_dbm":      &r.sensitivity,
+		"noise_floor_dbm":      &r.noiseFloor,
+		"path_loss_exponent":   &exponent,
+		"reference_distance_m": &referenceDistance,
+		"shadowing_sigma_db":   &sigma,
+		"obstacle_loss_db":     &obstacleLoss,
+	})
+	if err != nil {
+		return
+	}
+	if frequencyMHz <= 0 || referenceDistance <= 0 {
+		return fmt.Errorf("frequency_mhz and reference_distance_m should be positive")
+	}
+	frequency := frequencyMHz * 1e6
+	switch model := vals["path_loss"]; model {
+	case "free_space":
+		r.pathLoss = &propagation.FreeSpace{Frequency: frequency}
+	case "two_ray_ground":
+		r.pathLoss = &propagation.TwoRayGround{Frequency: frequency}
+	case "log_distance", "":
+		r.pathLoss = propagation.NewLogDistance(frequency, referenceDistance, exponent)
+	case "log_normal_shadowing":
+		if !r.seeded {
+			r.seed = time.Now().UnixNano()
+		}
+		r.pathLoss = propagation.NewLogNormalShadowing(propagation.NewLogDistance(frequency, referenceDistance, exponent), sigma, r.seed)
+	default:
+		return fmt.Errorf("unknown path_loss: %q", model)
+	}
+	if name, ok := vals["obstacles_path"]; ok {
+		var obstacles *propagation.Obstacles
+		if obstacles, err = propagation.LoadGeoJSON(name, obstacleLoss); err != nil {
+			return fmt.Errorf("loading obstacles from %s: %v", name, err)
+		}
+		r.pathLoss = &propagation.Obstructed{PathLoss: r.pathLoss, Obstacles: obstacles}
+	}
+	return
+}
+
+func (r *RxPower) Initialize(positionManager squirrel.PositionManager) {
+	r.positionManager = positionManager
+	r.enabled.track(positionManager, nil)
+}
+
+// decide decides upon a frame from source to destination.
+func (r *RxPower) decide(source int, destination int) squirrel.Result {
+	tx, err1 := r.positionManager.Get(source)
+	rx, err2 := r.positionManager.Get(destination)
+	if err1 != nil || err2 != nil {
+		return squirrel.Result{Destination: destination, Reason: squirrel.DroppedOutOfRange, SINR: math.NaN()}
+	}
+	power := r.txPower + 2*r.antennaGain - r.pathLoss.Loss(&tx, &rx)
+	result := squirrel.Result{Destination: destination, Delivered: power >= r.sensitivity, SINR: power - r.noiseFloor}
+	if !result.Delivered {
+		result.Reason = squirrel.DroppedOutOfRange
+	}
+	return result
+}
+
+func (r *RxPower) SendUnicast(source int, destination int, frame *squirrel.Frame) squirrel.Result {
+	return r.decide(source, destination)
+}
+
+func (r *RxPower) SendBroadcast(source int, frame *squirrel.Frame, underlying []squirrel.Result) []squirrel.Result {
+	results := underlying[:0]
+	for _, id := range r.enabled.get() {
+		if id != source {
+			results = append(results, r.decide(source, id))
+		}
+	}
+	return results
+}
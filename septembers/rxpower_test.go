@@ -0,0 +1,241 @@
+package septembers
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/coreos/go-etcd/etcd"
+	"github.com/squirrel-land/squirrel"
+	"github.com/squirrel-land/squirrel/propagation"
+)
+
+// fakePositionManager is a PositionManager of nodes that are enabled as soon
+// as they're placed.
+type fakePositionManager struct {
+	mu             sync.Mutex
+	positions      map[int]squirrel.Position
+	enabled        map[int]bool
+	enabledChanged []chan<- []int
+}
+
+func newFakePositionManager() *fakePositionManager {
+	return &fakePositionManager{positions: make(map[int]squirrel.Position), enabled: make(map[int]bool)}
+}
+
+func (p *fakePositionManager) Capacity() int { return 256 }
+
+func (p *fakePositionManager) Get(index int) (squirrel.Position, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.enabled[index] {
+		return squirrel.Position{}, fmt.Errorf("node with index %d is disabled", index)
+	}
+	return p.positions[index], nil
+}
+
+func (p *fakePositionManager) GetAddr(hardAddr string) (squirrel.Position, error) {
+	return squirrel.Position{}, fmt.Errorf("node with hardware address %s is not found", hardAddr)
+}
+
+func (p *fakePositionManager) Distance(index1, index2 int) float64 {
+	pos1, err1 := p.Get(index1)
+	pos2, err2 := p.Get(index2)
+	if err1 != nil || err2 != nil {
+		return math.MaxFloat64
+	}
+	return propagation.Distance(&pos1, &pos2)
+}
+
+func (p *fakePositionManager) SetPosition(index int, pos *squirrel.Position) error {
+	p.mu.Lock()
+	p.positions[index] = *pos
+	p.mu.Unlock()
+	p.Enable(index)
+	return nil
+}
+
+func (p *fakePositionManager) Set(index int, x, y, height float64) error {
+	return p.SetPosition(index, &squirrel.Position{X: x, Y: y, Height: height})
+}
+
+func (p *fakePositionManager) SetPositionAddr(hardAddr string, pos *squirrel.Position) error {
+	return fmt.Errorf("node with hardware address %s is not found", hardAddr)
+}
+
+func (p *fakePositionManager) SetAddr(hardAddr string, x, y, height float64) error {
+	return fmt.Errorf("node with hardware address %s is not found", hardAddr)
+}
+
+func (p *fakePositionManager) Enable(index int)  { p.setEnabled(index, true) }
+func (p *fakePositionManager) Disable(index int) { p.setEnabled(index, false) }
+
+func (p *fakePositionManager) setEnabled(index int, enabled bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.enabled[index] = enabled
+	for _, c := range p.enabledChanged {
+		c <- p.enabledLocked()
+	}
+}
+
+func (p *fakePositionManager) IsEnabled(index int) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.enabled[index]
+}
+
+func (p *fakePositionManager) Enabled() []int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.enabledLocked()
+}
+
+func (p *fakePositionManager) enabledLocked() []int {
+	e := make([]int, 0, len(p.enabled))
+	for id, enabled := range p.enabled {
+		if enabled {
+			e = append(e, id)
+		}
+	}
+	sort.Ints(e)
+	return e
+}
+
+func (p *fakePositionManager) RegisterEnabledChanged(channel chan<- []int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.enabledChanged = append(p.enabledChanged, channel)
+}
+
+// config returns a configuration Dir with values, given as key and value
+// pairs.
+func config(keyValues ...string) *etcd.Node {
+	conf := &etcd.Node{Key: "/conf", Dir: true}
+	for i := 0; i+1 < len(keyValues); i += 2 {
+		conf.Nodes = append(conf.Nodes, &etcd.Node{Key: "/conf/" + keyValues[i], Value: keyValues[i+1]})
+	}
+	return conf
+}
+
+func TestRxPower(t *testing.T) {
+	// a 6 dB wall between 1 and 4, and an opaque one between 1 and 5
+	const geoJSON = `{"type": "FeatureCollection", "features": [
+		{"type": "Feature", "properties": {"attenuation_db": 6},
+		 "geometry": {"type": "LineString", "coordinates": [[-50, -10], [-50, 10]]}},
+		{"type": "Feature", "properties": {"opaque": true},
+		 "geometry": {"type": "LineString", "coordinates": [[-10, 50], [10, 50]]}}
+	]}`
+	obstaclesPath := filepath.Join(t.TempDir(), "obstacles.geojson")
+	if err := os.WriteFile(obstaclesPath, []byte(geoJSON), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	positionManager := newFakePositionManager()
+	positionManager.Set(1, 0, 0, 0)
+	positionManager.Set(2, 100, 0, 0)  // in range
+	positionManager.Set(3, 5000, 0, 0) // out of range
+	positionManager.Set(4, -100, 0, 0) // in range, behind the 6 dB wall
+	positionManager.Set(5, 0, 100, 0)  // in range, behind the opaque wall
+	positionManager.Set(6, 10, 0, 0)
+	positionManager.Disable(6)
+
+	freeSpace := &propagation.FreeSpace{Frequency: 2412e6}
+	power := func(id int) float64 {
+		tx, _ := positionManager.Get(1)
+		rx, _ := positionManager.Get(id)
+		return 20 - freeSpace.Loss(&tx, &rx)
+	}
+	tests := []struct {
+		name      string
+		conf      *etcd.Node
+		delivered map[int]bool
+		wallLoss  float64 // attenuation between 1 and 4
+	}{
+		{"without obstacles", config("path_loss", "free_space"),
+			map[int]bool{2: true, 3: false, 4: true, 5: true}, 0},
+		{"with obstacles", config("path_loss", "free_space", "obstacles_path", obstaclesPath),
+			map[int]bool{2: true, 3: false, 4: true, 5: false}, 6},
+	}
+	for _, test := range tests {
+		r := NewRxPower()
+		if err := r.Configure(test.conf); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		r.Initialize(positionManager)
+		results := r.SendBroadcast(1, nil, make([]squirrel.Result, 8))
+		if len(results) != len(test.delivered) {
+			t.Errorf("%s: got %d results, expected %d", test.name, len(results), len(test.delivered))
+		}
+		for _, result := range results {
+			delivered, ok := test.delivered[result.Destination]
+			if !ok {
+				t.Errorf("%s: got a result for %d", test.name, result.Destination)
+				continue
+			}
+			if result.Delivered != delivered || (!delivered && result.Reason != squirrel.DroppedOutOfRange) {
+				t.Errorf("%s: got %+v for %d, expected delivered %v", test.name, result, result.Destination, delivered)
+			}
+			if unicast := r.SendUnicast(1, result.Destination, nil); unicast != result {
+				t.Errorf("%s: unicast frame to %d got %+v, broadcast got %+v", test.name, result.Destination, unicast, result)
+			}
+		}
+		for _, result := range results {
+			if result.Destination == 2 || result.Destination == 4 {
+				expected := power(result.Destination) + 95
+				if result.Destination == 4 {
+					expected -= test.wallLoss
+				}
+				if math.Abs(result.SINR-expected) > 1e-9 {
+					t.Errorf("%s: SINR at %d is %v, expected %v", test.name, result.Destination, result.SINR, expected)
+				}
+			}
+		}
+		if r := r.SendUnicast(1, 6, nil); r.Delivered || r.Reason != squirrel.DroppedOutOfRange {
+			t.Errorf("%s: got %+v for a disabled node", test.name, r)
+		}
+	}
+}
+
+func TestRxPowerSensitivity(t *testing.T) {
+	positionManager := newFakePositionManager()
+	positionManager.Set(1, 0, 0, 0)
+	positionManager.Set(2, 100, 0, 0)
+	tx, _ := positionManager.Get(1)
+	rx, _ := positionManager.Get(2)
+	power := 20 - (&propagation.FreeSpace{Frequency: 2412e6}).Loss(&tx, &rx)
+	for _, test := range []struct {
+		sensitivity float64
+		delivered   bool
+	}{
+		{power - 1, true},
+		{power, true},
+		{power + 1, false},
+	} {
+		r := NewRxPower()
+		if err := r.Configure(config("path_loss", "free_space", "sensitivity_dbm", fmt.Sprint(test.sensitivity))); err != nil {
+			t.Fatal(err)
+		}
+		r.Initialize(positionManager)
+		if got := r.SendUnicast(1, 2, nil); got.Delivered != test.delivered {
+			t.Errorf("sensitivity %v dBm for %v dBm received: got delivered %v", test.sensitivity, power, got.Delivered)
+		}
+	}
+}
+
+func TestRxPowerConfigureErrors(t *testing.T) {
+	for _, conf := range []*etcd.Node{
+		config("path_loss", "unknown"),
+		config("frequency_mhz", "0"),
+		config("tx_power_dbm", "x"),
+		config("obstacles_path", filepath.Join(t.TempDir(), "missing.geojson")),
+	} {
+		if err := NewRxPower().Configure(conf); err == nil {
+			t.Errorf("%v: expected an error", conf.Nodes[0])
+		}
+	}
+}
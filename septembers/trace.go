@@ -0,0 +1,329 @@
+package septembers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/coreos/go-etcd/etcd"
+	"github.com/squirrel-land/squirrel"
+)
+
+// Trace is a September that replays per-link measurements recorded on a real
+// network. A trace is a series of samples of links, each of which holds from
+// its time until the next sample of the same link. Time in a trace is elapsed
+// experiment time, i.e. emulated time since Master started.
+//
+// Nodes are referred to in a trace by names they joined with, hardware
+// addresses, or identities.
+type Trace struct {
+	links       map[nodeLink][]traceSample
+	defaultLoss float64
+	noiseFloor  float64
+
+	seed   int64
+	seeded bool
+	rng    *rand.Rand
+	mu     sync.Mutex // for rng
+
+	nodes
+	clock squirrel.Clock
+	start time.Time
+
+	// resolved is re-resolved from links whenever nodes are enabled or
+	// disabled.
+	resolved atomic.Value // *resolvedTrace
+	enabled  enabledNodes
+}
+
+type traceSample struct {
+	at   time.Duration
+	loss float64 // probability
+	rssi float64 // dBm; NaN if not recorded
+}
+
+// traceRecord is a line in a trace file.
+type traceRecord struct {
+	link nodeLink
+	traceSample
+}
+
+type resolvedTrace struct {
+	enabled []int
+	links   map[[2]int][]traceSample // by identities of source and destination
+}
+
+func NewTrace() *Trace {
+	return new(Trace)
+}
+
+func (t *Trace) ParametersHelp() string {
+	return `Trace replays per-link measurements, e.g. loss rates or RSSI recorded on a
+testbed, according to elapsed experiment time.
+
+  path            : path of the trace file, which is CSV or JSON.
+  format          : csv or json. Default: by extension of path
+  default_loss    : loss probability of links before their first sample, and
+                    of links not in the trace. Default: 1
+  noise_floor_dbm : noise power, for SINR in results. Default: -95
+
+A CSV trace has a header line naming columns, which are in any order:
+
+  time        : seconds since the experiment started.
+  source      : name, hardware address, or identity of the sender.
+  destination : name, hardware address, or identity of the receiver.
+  loss        : probability that a frame is lost, from 0 to 1; or
+  delivered   : 1 if frames are delivered, 0 if not.
+  rssi        : received signal strength (in dBm). [Optional]
+
+A JSON trace is an array of objects with the same keys.
+`
+}
+
+// Seed implements squirrel.Seeder.
+func (t *Trace) Seed(seed int64) {
+	t.seed = seed
+	t.seeded = true
+}
+
+// SetClock implements squirrel.ClockUser.
+func (t *Trace) SetClock(clock squirrel.Clock) {
+	t.clock = clock
+}
+
+func (t *Trace) Configure(conf *etcd.Node) (err error) {
+	t.defaultLoss, t.noiseFloor = 1, -95
+	vals := values(conf)
+	if err = parseFloats(vals, map[string]*float64{
+		"default_loss":    &t.defaultLoss,
+		"noise_floor_dbm": &t.noiseFloor,
+	}); err != nil {
+		return
+	}
+	name, ok := vals["path"]
+	if !ok {
+		return fmt.Errorf("path is required")
+	}
+	format, ok := vals["format"]
+	if !ok {
+		format = strings.TrimPrefix(strings.ToLower(filepath.Ext(name)), ".")
+	}
+	file, err := os.Open(name)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	var records []traceRecord
+	switch format {
+	case "csv":
+		records, err = readCSVTrace(file)
+	case "json":
+		records, err = readJSONTrace(file)
+	default:
+		err = fmt.Errorf("unknown trace format: %q", format)
+	}
+	if err != nil {
+		return fmt.Errorf("reading %s: %v", name, err)
+	}
+	t.links = make(map[nodeLink][]traceSample)
+	for _, r := range records {
+		t.links[r.link] = append(t.links[r.link], r.traceSample)
+	}
+	for _, samples := range t.links {
+		sort.SliceStable(samples, func(i, j int) bool { return samples[i].at < samples[j].at })
+	}
+	if !t.seeded {
+		t.seed = time.Now().UnixNano()
+	}
+	t.rng = rand.New(rand.NewSource(t.seed))
+	return
+}
+
+func readCSVTrace(r io.Reader) (records []traceRecord, err error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		return
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range []string{"time", "source", "destination"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("no %s column", name)
+		}
+	}
+	lossColumn, isLoss := columns["loss"]
+	deliveredColumn, isDelivered := columns["delivered"]
+	if !isLoss && !isDelivered {
+		return nil, fmt.Errorf("no loss or delivered column")
+	}
+	rssiColumn, isRSSI := columns["rssi"]
+	for line := 2; ; line++ {
+		var row []string
+		if row, err = reader.Read(); err == io.EOF {
+			return records, nil
+		} else if err != nil {
+			return
+		}
+		var (
+			record     traceRecord
+			at, d      float64
+			parseError error
+		)
+		parse := func(column int, v *float64) {
+			if parseError == nil {
+				*v, parseError = strconv.ParseFloat(strings.TrimSpace(row[column]), 64)
+			}
+		}
+		record.link = nodeLink{source: strings.TrimSpace(row[columns["source"]]), destination: strings.TrimSpace(row[columns["destination"]])}
+		record.rssi = math.NaN()
+		parse(columns["time"], &at)
+		if isLoss {
+			parse(lossColumn, &record.loss)
+		} else {
+			parse(deliveredColumn, &d)
+			record.loss = 1 - d
+		}
+		if isRSSI && strings.TrimSpace(row[rssiColumn]) != "" {
+			parse(rssiColumn, &record.rssi)
+		}
+		if parseError != nil {
+			return nil, fmt.Errorf("line %d: %v", line, parseError)
+		}
+		record.at = time.Duration(at * float64(time.Second))
+		records = append(records, record)
+	}
+}
+
+// traceNode is how a node is referred to in a JSON trace, which can be a
+// number for identities.
+type traceNode string
+
+func (n *traceNode) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*n = traceNode(s)
+		return nil
+	}
+	var identity int
+	if err := json.Unmarshal(data, &identity); err != nil {
+		return fmt.Errorf("a node should be a string or an identity: %s", data)
+	}
+	*n = traceNode(strconv.Itoa(identity))
+	return nil
+}
+
+func readJSONTrace(r io.Reader) (records []traceRecord, err error) {
+	var entries []struct {
+		Time        float64   `json:"time"`
+		Source      traceNode `json:"source"`
+		Destination traceNode `json:"destination"`
+		Loss        *float64  `json:"loss"`
+		Delivered   *bool     `json:"delivered"`
+		RSSI        *float64  `json:"rssi"`
+	}
+	if err = json.NewDecoder(r).Decode(&entries); err != nil {
+		return
+	}
+	for i, e := range entries {
+		record := traceRecord{link: nodeLink{source: string(e.Source), destination: string(e.Destination)}}
+		record.at = time.Duration(e.Time * float64(time.Second))
+		record.rssi = math.NaN()
+		switch {
+		case e.Loss != nil:
+			record.loss = *e.Loss
+		case e.Delivered != nil:
+			if !*e.Delivered {
+				record.loss = 1
+			}
+		default:
+			return nil, fmt.Errorf("entry %d has neither loss nor delivered", i)
+		}
+		if e.RSSI != nil {
+			record.rssi = *e.RSSI
+		}
+		records = append(records, record)
+	}
+	return
+}
+
+func (t *Trace) Initialize(positionManager squirrel.PositionManager) {
+	t.start = t.now()
+	t.enabled.track(positionManager, t.resolve)
+}
+
+func (t *Trace) now() time.Time {
+	if t.clock == nil {
+		return time.Now()
+	}
+	return t.clock.Now()
+}
+
+func (t *Trace) resolve(enabled []int) {
+	r := &resolvedTrace{enabled: enabled, links: make(map[[2]int][]traceSample)}
+	for link, samples := range t.links {
+		if ids, ok := t.identities(link); ok {
+			r.links[ids] = samples
+		}
+	}
+	t.resolved.Store(r)
+}
+
+// decide decides upon a frame from source to destination, elapsed into the
+// experiment.
+func (t *Trace) decide(r *resolvedTrace, elapsed time.Duration, source int, destination int) squirrel.Result {
+	loss, rssi := t.defaultLoss, math.NaN()
+	samples := r.links[[2]int{source, destination}]
+	// the last sample that is not later than elapsed
+	if i := sort.Search(len(samples), func(i int) bool { return samples[i].at > elapsed }) - 1; i >= 0 {
+		loss, rssi = samples[i].loss, samples[i].rssi
+	}
+	result := squirrel.Result{Destination: destination, SINR: rssi - t.noiseFloor}
+	switch {
+	case loss >= 1:
+		result.Reason = squirrel.DroppedOutOfRange
+	case loss <= 0:
+		result.Delivered = true
+	default:
+		t.mu.Lock()
+		result.Delivered = t.rng.Float64() >= loss
+		t.mu.Unlock()
+		if !result.Delivered {
+			result.Reason = squirrel.DroppedUnknown
+		}
+	}
+	return result
+}
+
+func (t *Trace) SendUnicast(source int, destination int, frame *squirrel.Frame) squirrel.Result {
+	t.enabled.has(source)
+	t.enabled.has(destination)
+	return t.decide(t.resolved.Load().(*resolvedTrace), t.now().Sub(t.start), source, destination)
+}
+
+func (t *Trace) SendBroadcast(source int, frame *squirrel.Frame, underlying []squirrel.Result) []squirrel.Result {
+	t.enabled.has(source)
+	r := t.resolved.Load().(*resolvedTrace)
+	elapsed := t.now().Sub(t.start)
+	results := underlying[:0]
+	for _, id := range r.enabled {
+		if id != source {
+			results = append(results, t.decide(r, elapsed, source, id))
+		}
+	}
+	return results
+}
@@ -0,0 +1,124 @@
+package septembers
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func record(source, destination string, at time.Duration, loss, rssi float64) traceRecord {
+	return traceRecord{link: nodeLink{source: source, destination: destination}, traceSample: traceSample{at: at, loss: loss, rssi: rssi}}
+}
+
+// sameRecords is like reflect.DeepEqual, but takes NaN RSSI as equal.
+func sameRecords(a, b []traceRecord) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		x, y := a[i], b[i]
+		if math.IsNaN(x.rssi) && math.IsNaN(y.rssi) {
+			x.rssi, y.rssi = 0, 0
+		}
+		if x != y {
+			return false
+		}
+	}
+	return true
+}
+
+func TestReadCSVTrace(t *testing.T) {
+	nan := math.NaN()
+	tests := []struct {
+		name     string
+		trace    string
+		expected []traceRecord
+		err      bool
+	}{
+		{
+			name:  "loss and rssi",
+			trace: "time,source,destination,loss,rssi\n0,node1,node2,0.1,-60\n1.5, 02:00:00:00:00:01 , 3 ,0.25,\n",
+			expected: []traceRecord{
+				record("node1", "node2", 0, 0.1, -60),
+				record("02:00:00:00:00:01", "3", 1500*time.Millisecond, 0.25, nan),
+			},
+		},
+		{
+			name:  "delivered, columns in any order",
+			trace: "Delivered, Destination, Source, Time\n1,b,a,0\n0,b,a,2\n",
+			expected: []traceRecord{
+				record("a", "b", 0, 0, nan),
+				record("a", "b", 2*time.Second, 1, nan),
+			},
+		},
+		{name: "header only", trace: "time,source,destination,loss\n"},
+		{name: "empty", trace: "", err: true},
+		{name: "no destination", trace: "time,source,loss\n0,a,0\n", err: true},
+		{name: "no loss nor delivered", trace: "time,source,destination\n0,a,b\n", err: true},
+		{name: "not a number", trace: "time,source,destination,loss\n0,a,b,x\n", err: true},
+		{name: "short row", trace: "time,source,destination,loss\n0,a,b\n", err: true},
+	}
+	for _, test := range tests {
+		records, err := readCSVTrace(strings.NewReader(test.trace))
+		if test.err {
+			if err == nil {
+				t.Errorf("%s: expected an error", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if !sameRecords(records, test.expected) {
+			t.Errorf("%s: got %+v, expected %+v", test.name, records, test.expected)
+		}
+	}
+}
+
+func TestReadJSONTrace(t *testing.T) {
+	nan := math.NaN()
+	tests := []struct {
+		name     string
+		trace    string
+		expected []traceRecord
+		err      bool
+	}{
+		{
+			name:  "loss and rssi",
+			trace: `[{"time": 0.5, "source": "node1", "destination": 2, "loss": 0.1, "rssi": -70}]`,
+			expected: []traceRecord{
+				record("node1", "2", 500*time.Millisecond, 0.1, -70),
+			},
+		},
+		{
+			name:  "delivered",
+			trace: `[{"time": 0, "source": "a", "destination": "b", "delivered": true}, {"time": 1, "source": "a", "destination": "b", "delivered": false}]`,
+			expected: []traceRecord{
+				record("a", "b", 0, 0, nan),
+				record("a", "b", time.Second, 1, nan),
+			},
+		},
+		{name: "empty array", trace: `[]`},
+		{name: "neither loss nor delivered", trace: `[{"time": 0, "source": "a", "destination": "b"}]`, err: true},
+		{name: "invalid node", trace: `[{"time": 0, "source": true, "destination": "b", "loss": 0}]`, err: true},
+		{name: "not an array", trace: `{"time": 0}`, err: true},
+	}
+	for _, test := range tests {
+		records, err := readJSONTrace(strings.NewReader(test.trace))
+		if test.err {
+			if err == nil {
+				t.Errorf("%s: expected an error", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if !sameRecords(records, test.expected) {
+			t.Errorf("%s: got %+v, expected %+v", test.name, records, test.expected)
+		}
+	}
+}
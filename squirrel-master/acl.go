@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/coreos/go-etcd/etcd"
+	"github.com/songgao/packets/ethernet"
+	"github.com/squirrel-land/squirrel"
+)
+
+// aclRule matches frames on any combination of fields. Fields that are not
+// set (-1 or nil) match anything.
+type aclRule struct {
+	hits uint64 // accessed atomically; first for 64-bit alignment
+
+	text  string
+	allow bool
+
+	src, dst         int
+	srcMAC, dstMAC   net.HardwareAddr
+	etherType        int
+	ipProtocol       int
+	srcPort, dstPort int
+	port             int // either srcPort or dstPort
+}
+
+var (
+	etherTypeNames  = map[string]int{"ipv4": 0x0800, "arp": 0x0806, "ipv6": 0x86dd}
+	ipProtocolNames = map[string]int{"icmp": 1, "igmp": 2, "tcp": 6, "udp": 17, "icmpv6": 58, "sctp": 132}
+)
+
+func parseNamedInt(s string, names map[string]int) (int, error) {
+	if v, ok := names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.ParseInt(s, 0, 32)
+	return int(v), err
+}
+
+// parseACLRule parses a rule like:
+//
+//	deny src=5 dst=7
+//	deny ethertype=ipv6
+//	allow ip_proto=udp dst_port=53
+//
+// Valid fields are src, dst (identities), src_mac, dst_mac, ethertype (a
+// number, or ipv4, arp, ipv6), ip_proto (a number, or icmp, igmp, tcp, udp,
+// icmpv6, sctp), src_port, dst_port and port (either of them).
+func parseACLRule(text string) (rule *aclRule, err error) {
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("empty rule")
+	}
+	rule = &aclRule{text: text, src: -1, dst: -1, etherType: -1, ipProtocol: -1, srcPort: -1, dstPort: -1, port: -1}
+	switch fields[0] {
+	case "allow":
+		rule.allow = true
+	case "deny":
+	default:
+		return nil, fmt.Errorf("rule %q: action should be allow or deny", text)
+	}
+	for _, field := range fields[1:] {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("rule %q: malformed field %q", text, field)
+		}
+		switch kv[0] {
+		case "src":
+			rule.src, err = strconv.Atoi(kv[1])
+		case "dst":
+			rule.dst, err = strconv.Atoi(kv[1])
+		case "src_mac":
+			rule.srcMAC, err = net.ParseMAC(kv[1])
+		case "dst_mac":
+			rule.dstMAC, err = net.ParseMAC(kv[1])
+		case "ethertype":
+			rule.etherType, err = parseNamedInt(kv[1], etherTypeNames)
+		case "ip_proto":
+			rule.ipProtocol, err = parseNamedInt(kv[1], ipProtocolNames)
+		case "src_port":
+			rule.srcPort, err = strconv.Atoi(kv[1])
+		case "dst_port":
+			rule.dstPort, err = strconv.Atoi(kv[1])
+		case "port":
+			rule.port, err = strconv.Atoi(kv[1])
+		default:
+			err = fmt.Errorf("unknown field %q", kv[0])
+		}
+		if err != nil {
+			return nil, fmt.Errorf("rule %q: %v", text, err)
+		}
+	}
+	return
+}
+
+func matchInt(rule, value int) bool {
+	return rule == -1 || rule == value
+}
+
+func matchMAC(rule, value net.HardwareAddr) bool {
+	return rule == nil || bytes.Equal(rule, value)
+}
+
+func (r *aclRule) match(source, destination int, frame *squirrel.Frame) bool {
+	var srcMAC, dstMAC net.HardwareAddr
+	if len(frame.Data) >= 14 {
+		srcMAC, dstMAC = ethernet.Frame(frame.Data).Source(), ethernet.Frame(frame.Data).Destination()
+	}
+	meta := &frame.Meta
+	return matchInt(r.src, source) && matchInt(r.dst, destination) &&
+		matchMAC(r.srcMAC, srcMAC) && matchMAC(r.dstMAC, dstMAC) &&
+		matchInt(r.etherType, meta.EtherType) && matchInt(r.ipProtocol, meta.IPProtocol) &&
+		matchInt(r.srcPort, meta.SrcPort) && matchInt(r.dstPort, meta.DstPort) &&
+		(r.port == -1 || r.port == meta.SrcPort || r.port == meta.DstPort)
+}
+
+// acl is an ordered list of rules applied to frames between identities, on top
+// of what September decides. The first rule that matches a frame decides
+// whether it is allowed; frames that no rule matches are allowed.
+type acl struct {
+	rules atomic.Value // []*aclRule
+}
+
+func newACL() *acl {
+	a := new(acl)
+	a.rules.Store([]*aclRule(nil))
+	return a
+}
+
+// Configure replaces rules with those in a configuration dir, where each value
+// is a rule, ordered by key. It is intended to be used with
+// common.WatchEtcdDir so that rules can be updated at runtime. Rules that are
+// kept, i.e. have the same text as a current rule, keep their hits.
+func (a *acl) Configure(dir *etcd.Node) {
+	var rules []*aclRule
+	if dir != nil {
+		nodes := make([]*etcd.Node, 0, len(dir.Nodes))
+		for _, node := range dir.Nodes {
+			if !node.Dir {
+				nodes = append(nodes, node)
+			}
+		}
+		sort.Slice(nodes, func(i, j int) bool { return path.Base(nodes[i].Key) < path.Base(nodes[j].Key) })
+		for _, node := range nodes {
+			rule, err := parseACLRule(node.Value)
+			if err != nil {
+				log.Printf("invalid ACL; keeping the current one: %s: %v\n", node.Key, err)
+				return
+			}
+			rules = append(rules, rule)
+		}
+	}
+	current := make(map[string][]*aclRule)
+	for _, rule := range a.rules.Load().([]*aclRule) {
+		current[rule.text] = append(current[rule.text], rule)
+	}
+	for _, rule := range rules {
+		if kept := current[rule.text]; len(kept) > 0 {
+			atomic.StoreUint64(&rule.hits, atomic.LoadUint64(&kept[0].hits))
+			current[rule.text] = kept[1:]
+		}
+	}
+	a.rules.Store(rules)
+	log.Printf("ACL updated with %d rules\n", len(rules))
+}
+
+// empty returns whether there's no rule, in which case every frame is allowed.
+func (a *acl) empty() bool {
+	return len(a.rules.Load().([]*aclRule)) == 0
+}
+
+// allow returns whether frame is allowed from source to destination.
+func (a *acl) allow(source, destination int, frame *squirrel.Frame) bool {
+	for _, rule := range a.rules.Load().([]*aclRule) {
+		if rule.match(source, destination, frame) {
+			atomic.AddUint64(&rule.hits, 1)
+			return rule.allow
+		}
+	}
+	return true
+}
+
+// filter removes recipients that frame is not allowed to reach from source, in
+// place, and returns the sub-slice of the rest.
+func (a *acl) filter(source int, frame *squirrel.Frame, recipients []int) []int {
+	n := 0
+	for _, id := range recipients {
+		if a.allow(source, id, frame) {
+			recipients[n] = id
+			n++
+		}
+	}
+	return recipients[:n]
+}
+
+type aclRuleStats struct {
+	Rule string `json:"rule"`
+	Hits uint64 `json:"hits"`
+}
+
+func (a *acl) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rules := a.rules.Load().([]*aclRule)
+	ret := make([]aclRuleStats, 0, len(rules))
+	for _, rule := range rules {
+		ret = append(ret, aclRuleStats{Rule: rule.text, Hits: atomic.LoadUint64(&rule.hits)})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(ret)
+}
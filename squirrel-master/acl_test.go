@@ -0,0 +1,105 @@
+package main
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/squirrel-land/squirrel"
+)
+
+func TestParseACLRule(t *testing.T) {
+	base := aclRule{src: -1, dst: -1, etherType: -1, ipProtocol: -1, srcPort: -1, dstPort: -1, port: -1}
+	with := func(f func(r *aclRule)) *aclRule {
+		r := base
+		f(&r)
+		return &r
+	}
+	tests := []struct {
+		text     string
+		expected *aclRule
+	}{
+		{"deny", with(func(r *aclRule) {})},
+		{"allow", with(func(r *aclRule) { r.allow = true })},
+		{"deny src=5 dst=7", with(func(r *aclRule) { r.src, r.dst = 5, 7 })},
+		{"deny ethertype=ipv6", with(func(r *aclRule) { r.etherType = 0x86dd })},
+		{"deny ethertype=0x0806", with(func(r *aclRule) { r.etherType = 0x0806 })},
+		{"allow ip_proto=UDP dst_port=53", with(func(r *aclRule) { r.allow, r.ipProtocol, r.dstPort = true, 17, 53 })},
+		{"deny ip_proto=47", with(func(r *aclRule) { r.ipProtocol = 47 })},
+		{"deny src_port=1 port=2", with(func(r *aclRule) { r.srcPort, r.port = 1, 2 })},
+		{"deny src_mac=02:00:00:00:00:01 dst_mac=02:00:00:00:00:02", with(func(r *aclRule) {
+			r.srcMAC = net.HardwareAddr{2, 0, 0, 0, 0, 1}
+			r.dstMAC = net.HardwareAddr{2, 0, 0, 0, 0, 2}
+		})},
+		{"", nil},
+		{"drop src=1", nil},
+		{"deny src", nil},
+		{"deny src=x", nil},
+		{"deny ethertype=ipx", nil},
+		{"deny src_mac=02:00", nil},
+		{"deny vlan=3", nil},
+	}
+	for _, test := range tests {
+		rule, err := parseACLRule(test.text)
+		if test.expected == nil {
+			if err == nil {
+				t.Errorf("%q: expected an error", test.text)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", test.text, err)
+			continue
+		}
+		test.expected.text = test.text
+		if !reflect.DeepEqual(rule, test.expected) {
+			t.Errorf("%q: got %+v, expected %+v", test.text, rule, test.expected)
+		}
+	}
+}
+
+func TestACLAllow(t *testing.T) {
+	a := newACL()
+	a.Configure(dir("/acl",
+		value("/acl/1", "allow src=1 ip_proto=udp port=53"),
+		value("/acl/2", "deny src=1"),
+	))
+	meta := func(port int) squirrel.FrameMeta {
+		return squirrel.FrameMeta{EtherType: 0x0800, IPProtocol: 17, SrcPort: 40000, DstPort: port}
+	}
+	tests := []struct {
+		source   int
+		meta     squirrel.FrameMeta
+		expected bool
+	}{
+		{1, meta(53), true},
+		{1, meta(80), false},
+		{2, meta(80), true},
+	}
+	for _, test := range tests {
+		if got := a.allow(test.source, 3, &squirrel.Frame{Meta: test.meta}); got != test.expected {
+			t.Errorf("frame from %d with %+v: allowed is %v, expected %v", test.source, test.meta, got, test.expected)
+		}
+	}
+	if recipients := a.filter(1, &squirrel.Frame{Meta: meta(80)}, []int{2, 3}); len(recipients) != 0 {
+		t.Errorf("recipients are %v, expected none", recipients)
+	}
+}
+
+func TestACLReloadKeepsHits(t *testing.T) {
+	a := newACL()
+	a.Configure(dir("/acl", value("/acl/1", "deny src=1"), value("/acl/2", "deny src=2")))
+	for i := 0; i < 3; i++ {
+		a.allow(1, 3, new(squirrel.Frame))
+	}
+	a.allow(2, 3, new(squirrel.Frame))
+	a.Configure(dir("/acl", value("/acl/0", "deny src=4"), value("/acl/1", "deny src=1"), value("/acl/2", "deny src=2 dst=3")))
+	hits := make(map[string]uint64)
+	for _, rule := range a.rules.Load().([]*aclRule) {
+		hits[rule.text] = rule.hits
+	}
+	expected := map[string]uint64{"deny src=4": 0, "deny src=1": 3, "deny src=2 dst=3": 0}
+	if !reflect.DeepEqual(hits, expected) {
+		t.Errorf("hits are %v, expected %v", hits, expected)
+	}
+}
@@ -8,11 +8,12 @@ import (
 
 type addressReverse struct {
 	addrs map[string]int
+	names map[string]int
 	sync.RWMutex
 }
 
 func newAddressReverse() *addressReverse {
-	return &addressReverse{addrs: make(map[string]int)}
+	return &addressReverse{addrs: make(map[string]int), names: make(map[string]int)}
 }
 
 func (a *addressReverse) Add(addr net.HardwareAddr, identity int) {
@@ -21,10 +22,15 @@ func (a *addressReverse) Add(addr net.HardwareAddr, identity int) {
 	a.addrs[strings.ToLower(addr.String())] = identity
 }
 
-func (a *addressReverse) Remove(addr net.HardwareAddr) {
+// Remove removes addr, if it's mapped to identity. A client rejoining with the
+// same address may have been mapped to another identity already.
+func (a *addressReverse) Remove(addr net.HardwareAddr, identity int) {
 	a.Lock()
 	defer a.Unlock()
-	delete(a.addrs, strings.ToLower(addr.String()))
+	key := strings.ToLower(addr.String())
+	if a.addrs[key] == identity {
+		delete(a.addrs, key)
+	}
 }
 
 func (a *addressReverse) Get(addr net.HardwareAddr) (identity int, ok bool) {
@@ -38,3 +44,27 @@ func (a *addressReverse) GetS(addr string) (identity int, ok bool) {
 	identity, ok = a.addrs[strings.ToLower(addr)]
 	return
 }
+
+// AddName maps name to identity. If another node has joined with the same
+// name, name is mapped to the latest one.
+func (a *addressReverse) AddName(name string, identity int) {
+	a.Lock()
+	defer a.Unlock()
+	a.names[name] = identity
+}
+
+// RemoveName removes name, if it's mapped to identity.
+func (a *addressReverse) RemoveName(name string, identity int) {
+	a.Lock()
+	defer a.Unlock()
+	if a.names[name] == identity {
+		delete(a.names, name)
+	}
+}
+
+func (a *addressReverse) GetName(name string) (identity int, ok bool) {
+	a.RLock()
+	defer a.RUnlock()
+	identity, ok = a.names[name]
+	return
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/coreos/go-etcd/etcd"
+	"github.com/squirrel-land/squirrel"
+)
+
+type captureConfig struct {
+	Enabled bool
+
+	// Dir is the directory capture files are written into.
+	Dir string
+
+	// Global enables a capture file that has all frames.
+	Global bool
+
+	// PerIdentity enables a capture file for each identity that has frames
+	// sent by, addressed to, or delivered to it.
+	PerIdentity bool
+
+	// RotateSize is the size in bytes after which a capture file is closed and
+	// a new one started. 0 means never.
+	RotateSize int64
+}
+
+func parseCaptureConfig(dir *etcd.Node) (conf captureConfig, err error) {
+	conf.Dir = "."
+	conf.Global = true
+	if dir == nil {
+		return
+	}
+	for _, node := range dir.Nodes {
+		if node.Dir {
+			continue
+		}
+		switch path.Base(node.Key) {
+		case "enabled":
+			conf.Enabled, err = strconv.ParseBool(node.Value)
+		case "dir":
+			conf.Dir = node.Value
+		case "global":
+			conf.Global, err = strconv.ParseBool(node.Value)
+		case "per_identity":
+			conf.PerIdentity, err = strconv.ParseBool(node.Value)
+		case "rotate_size_mb":
+			var mb float64
+			if mb, err = strconv.ParseFloat(node.Value, 64); err == nil {
+				conf.RotateSize = int64(mb * 1024 * 1024)
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+type captureRecord struct {
+	time        time.Time
+	frame       []byte
+	source      int
+	destination int
+	recipients  []int
+}
+
+// describeFrame returns a human readable description of what master did with
+// a frame.
+func describeFrame(source int, destination int, recipients []int) string {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "source=%d destination=", source)
+	if destination == 0 {
+		b.WriteString("group/unknown")
+	} else {
+		b.WriteString(strconv.Itoa(destination))
+	}
+	if len(recipients) == 0 {
+		b.WriteString(" dropped")
+		return b.String()
+	}
+	b.WriteString(" delivered recipients=")
+	for i, id := range recipients {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(strconv.Itoa(id))
+	}
+	return b.String()
+}
+
+type captureFile struct {
+	prefix string
+	seq    int
+	f      *os.File
+	bw     *bufio.Writer
+	w      *pcapngWriter
+}
+
+// capture writes frames that go through master into pcapng files, with a
+// comment on each frame describing its source, destination and recipients.
+// Frames are handed over to a dedicated routine, so that disk I/O doesn't slow
+// down forwarding; they are dropped if the routine can't keep up.
+type capture struct {
+	enabled int32  // accessed atomically
+	dropped uint64 // accessed atomically
+	records chan *captureRecord
+	configs chan captureConfig
+	closing chan chan struct{}
+	clock   squirrel.Clock
+
+	// following fields are only accessed by run()
+	conf    captureConfig
+	files   map[int]*captureFile // key 0 is the global file
+	started string
+}
+
+func newCapture(clock squirrel.Clock) *capture {
+	c := &capture{
+		clock:   clock,
+		records: make(chan *captureRecord, 1024),
+		configs: make(chan captureConfig),
+		closing: make(chan chan struct{}),
+		files:   make(map[int]*captureFile),
+	}
+	go c.run()
+	return c
+}
+
+// Configure applies a configuration dir. It is intended to be used with
+// common.WatchEtcdDir so that capture can be toggled at runtime.
+func (c *capture) Configure(dir *etcd.Node) {
+	conf, err := parseCaptureConfig(dir)
+	if err != nil {
+		log.Printf("invalid capture configuration; keeping the current one: %v\n", err)
+		return
+	}
+	c.configs <- conf
+}
+
+func (c *capture) record(frame []byte, source int, destination int, recipients []int) {
+	if atomic.LoadInt32(&c.enabled) == 0 {
+		return
+	}
+	r := &captureRecord{
+		time:        c.clock.Now(),
+		frame:       append([]byte(nil), frame...),
+		source:      source,
+		destination: destination,
+		recipients:  append([]int(nil), recipients...),
+	}
+	select {
+	case c.records <- r:
+	default:
+		if atomic.AddUint64(&c.dropped, 1)%1000 == 1 {
+			log.Printf("capture can't keep up; %d frames missing from capture files so far\n", atomic.LoadUint64(&c.dropped))
+		}
+	}
+}
+
+// Close writes frames that are still queued, and flushes and closes capture
+// files. Frames are not captured after Close, until capture is configured
+// again.
+func (c *capture) Close() {
+	atomic.StoreInt32(&c.enabled, 0)
+	done := make(chan struct{})
+	c.closing <- done
+	<-done
+}
+
+func (c *capture) run() {
+	flush := time.NewTicker(time.Second)
+	for {
+		select {
+		case conf := <-c.configs:
+			c.closeAll()
+			c.conf = conf
+			c.started = time.Now().Format("20060102-150405")
+			if conf.Enabled {
+				if err := os.MkdirAll(conf.Dir, 0755); err != nil {
+					log.Printf("creating capture dir error: %v\n", err)
+				}
+				atomic.StoreInt32(&c.enabled, 1)
+				log.Printf("capture started in %s\n", conf.Dir)
+			} else {
+				atomic.StoreInt32(&c.enabled, 0)
+			}
+		case r := <-c.records:
+			c.handle(r)
+		case done := <-c.closing:
+			for queued := len(c.records); queued > 0; queued-- {
+				c.handle(<-c.records)
+			}
+			c.closeAll()
+			c.conf.Enabled = false
+			close(done)
+		case <-flush.C:
+			for _, f := range c.files {
+				f.bw.Flush()
+			}
+		}
+	}
+}
+
+func (c *capture) handle(r *captureRecord) {
+	if !c.conf.Enabled {
+		return
+	}
+	comment := describeFrame(r.source, r.destination, r.recipients)
+	if c.conf.Global {
+		c.write(0, r, comment)
+	}
+	if c.conf.PerIdentity {
+		c.write(r.source, r, comment)
+		if r.destination != 0 && r.destination != r.source {
+			c.write(r.destination, r, comment)
+		}
+		for _, id := range r.recipients {
+			if id != r.source && id != r.destination {
+				c.write(id, r, comment)
+			}
+		}
+	}
+}
+
+func (c *capture) write(identity int, r *captureRecord, comment string) {
+	f, ok := c.files[identity]
+	if ok && c.conf.RotateSize > 0 && f.w.Written() >= c.conf.RotateSize {
+		f.close()
+		f.seq++
+		if err := f.open(); err != nil {
+			log.Printf("rotating capture file error: %v\n", err)
+			delete(c.files, identity)
+			return
+		}
+	}
+	if !ok {
+		f = &captureFile{prefix: fmt.Sprintf("all-%s", c.started)}
+		if identity != 0 {
+			f.prefix = fmt.Sprintf("node%d-%s", identity, c.started)
+		}
+		f.prefix = filepath.Join(c.conf.Dir, f.prefix)
+		if err := f.open(); err != nil {
+			log.Printf("creating capture file error: %v\n", err)
+			return
+		}
+		c.files[identity] = f
+	}
+	if err := f.w.WritePacket(r.time, r.frame, comment); err != nil {
+		log.Printf("writing capture file error: %v\n", err)
+	}
+}
+
+func (c *capture) closeAll() {
+	for identity, f := range c.files {
+		f.close()
+		delete(c.files, identity)
+	}
+}
+
+func (f *captureFile) open() (err error) {
+	f.f, err = os.Create(fmt.Sprintf("%s.%d.pcapng", f.prefix, f.seq))
+	if err != nil {
+		return
+	}
+	f.bw = bufio.NewWriter(f.f)
+	if f.w, err = newPcapngWriter(f.bw); err != nil {
+		f.f.Close()
+	}
+	return
+}
+
+func (f *captureFile) close() {
+	f.bw.Flush()
+	f.f.Close()
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/coreos/go-etcd/etcd"
+	"github.com/squirrel-land/squirrel"
+)
+
+// defaultChannel is the name of the channel of the September master is created
+// with. Identities that are not assigned any channel are on it.
+const defaultChannel = "default"
+
+// channel is a medium of its own, with a September that only sees nodes on
+// the channel.
+type channel struct {
+	name          string
+	september     squirrel.SeptemberV2
+	bitErrorRater squirrel.BitErrorRater    // nil if September doesn't implement it
+	receiver      squirrel.FeedbackReceiver // nil if September doesn't implement it
+	view          *channelView
+}
+
+// channels dispatches decisions on frames to Septembers of channels. A frame is
+// sent on every channel its source is on: a unicast frame is delivered if
+// September of any channel shared by its source and destination approves, and
+// a broadcast frame is delivered to recipients chosen on all of its source's
+// channels. Nodes that share no channel are out of range of each other.
+type channels struct {
+	positionManager squirrel.PositionManager
+	addrReverse     *addressReverse
+
+	// list, byName and wantsFeedback only change before Run; list[0] is the
+	// default channel.
+	list   []*channel
+	byName map[string]*channel
+
+	// wantsFeedback is whether September of any channel is a
+	// squirrel.FeedbackReceiver.
+	wantsFeedback bool
+
+	assignment atomic.Value // map[int][]*channel; identities not in it are on default channel
+
+	// scratches are used to merge broadcast decisions of channels, for
+	// sources on more than one channel.
+	scratches sync.Pool // *channelsScratch
+}
+
+// channelsScratch holds what merging broadcast decisions of channels needs,
+// reused across frames.
+type channelsScratch struct {
+	results []squirrel.Result
+	index   []int // identity -> 1 + index in merged results, or 0
+}
+
+func newChannels(positionManager squirrel.PositionManager, addrReverse *addressReverse) *channels {
+	c := &channels{positionManager: positionManager, addrReverse: addrReverse, byName: make(map[string]*channel)}
+	c.assignment.Store(make(map[int][]*channel))
+	c.scratches.New = func() interface{} {
+		n := positionManager.Capacity()
+		return &channelsScratch{results: make([]squirrel.Result, n), index: make([]int, n)}
+	}
+	return c
+}
+
+// add adds a channel with september, and returns it. september is yet to be
+// initialized with the channel's view.
+func (c *channels) add(name string, september squirrel.SeptemberV2) (ch *channel, err error) {
+	if _, ok := c.byName[name]; ok {
+		return nil, fmt.Errorf("channel %q already exists", name)
+	}
+	ch = &channel{name: name, september: september}
+	ch.bitErrorRater, _ = squirrel.Implementation(september).(squirrel.BitErrorRater)
+	ch.receiver, _ = squirrel.Implementation(september).(squirrel.FeedbackReceiver)
+	c.wantsFeedback = c.wantsFeedback || ch.receiver != nil
+	ch.view = newChannelView(c.positionManager, c, ch)
+	c.list = append(c.list, ch)
+	c.byName[name] = ch
+	return
+}
+
+// of returns channels identity is on.
+func (c *channels) of(identity int) []*channel {
+	if chs, ok := c.assignment.Load().(map[int][]*channel)[identity]; ok {
+		return chs
+	}
+	return c.list[:1]
+}
+
+func (c *channels) isMember(ch *channel, identity int) bool {
+	for _, m := range c.of(identity) {
+		if m == ch {
+			return true
+		}
+	}
+	return false
+}
+
+// Assign replaces channel assignment with the one in a configuration dir,
+// where each key is an identity and its value a comma separated list of
+// channel names. It is intended to be used with common.WatchEtcdDir so that
+// channels can be reassigned at runtime.
+func (c *channels) Assign(dir *etcd.Node) {
+	assignment := make(map[int][]*channel)
+	if dir != nil {
+		for _, node := range dir.Nodes {
+			if node.Dir {
+				continue
+			}
+			identity, err := strconv.Atoi(path.Base(node.Key))
+			if err != nil {
+				log.Printf("invalid channel assignment; keeping the current one: %s is not an identity\n", node.Key)
+				return
+			}
+			var chs []*channel
+			for _, name := range strings.Split(node.Value, ",") {
+				ch, ok := c.byName[strings.TrimSpace(name)]
+				if !ok {
+					log.Printf("invalid channel assignment; keeping the current one: %s: unknown channel %q\n", node.Key, name)
+					return
+				}
+				chs = append(chs, ch)
+			}
+			assignment[identity] = chs
+		}
+	}
+	c.assignment.Store(assignment)
+	for _, ch := range c.list {
+		ch.view.notify()
+	}
+	log.Printf("channel assignment updated for %d identities\n", len(assignment))
+}
+
+// bitErrorRate returns the bit error rate between source and destination from
+// the first channel they share whose September implements
+// squirrel.BitErrorRater. ok is false if there isn't one.
+func (c *channels) bitErrorRate(source, destination int) (ber float64, ok bool) {
+	for _, ch := range c.of(source) {
+		if ch.bitErrorRater != nil && c.isMember(ch, destination) {
+			return ch.bitErrorRater.BitErrorRate(source, destination), true
+		}
+	}
+	return
+}
+
+// feedback tells f to Septembers that implement squirrel.FeedbackReceiver, of
+// channels shared by source and destination of f.
+func (c *channels) feedback(f *squirrel.Feedback) {
+	for _, ch := range c.of(f.Source) {
+		if ch.receiver != nil && c.isMember(ch, f.Destination) {
+			ch.receiver.Feedback(f)
+		}
+	}
+}
+
+func (c *channels) SendUnicast(source int, destination int, frame *squirrel.Frame) (ret squirrel.Result) {
+	ret = squirrel.Result{Destination: destination, Reason: squirrel.DroppedOutOfRange, SINR: math.NaN()}
+	for _, ch := range c.of(source) {
+		if !c.isMember(ch, destination) {
+			continue
+		}
+		if r := ch.september.SendUnicast(source, destination, frame); !ret.Delivered {
+			ret = r
+		}
+	}
+	return
+}
+
+func (c *channels) SendBroadcast(source int, frame *squirrel.Frame, underlying []squirrel.Result) []squirrel.Result {
+	chs := c.of(source)
+	if len(chs) == 1 {
+		return c.filter(chs[0], chs[0].september.SendBroadcast(source, frame, underlying))
+	}
+	s := c.scratches.Get().(*channelsScratch)
+	defer c.scratches.Put(s)
+	results := underlying[:0]
+	for _, ch := range chs {
+		for _, r := range c.filter(ch, ch.september.SendBroadcast(source, frame, s.results)) {
+			if i := s.index[r.Destination]; i == 0 {
+				results = append(results, r)
+				s.index[r.Destination] = len(results)
+			} else if !results[i-1].Delivered {
+				results[i-1] = r
+			}
+		}
+	}
+	for _, r := range results {
+		s.index[r.Destination] = 0
+	}
+	return results
+}
+
+// filter removes results for nodes that are not on ch in place, in case
+// September decided for one that was moved off ch while the decision was being
+// made.
+func (c *channels) filter(ch *channel, results []squirrel.Result) []squirrel.Result {
+	n := 0
+	for _, r := range results {
+		if c.isMember(ch, r.Destination) {
+			results[n] = r
+			n++
+		}
+	}
+	return results[:n]
+}
+
+// channelView is a PositionManager that only has nodes on a channel enabled.
+// It's what September of the channel is initialized with.
+type channelView struct {
+	squirrel.PositionManager
+	channels *channels
+	channel  *channel
+
+	// pending is signaled when enabled nodes of the channel may have changed,
+	// either because PositionManager's did or because of reassignment.
+	pending chan struct{}
+
+	mu             sync.Mutex // for enabled and enabledChanged
+	enabled        []int      // latest enabled nodes of PositionManager
+	enabledChanged []chan<- []int
+}
+
+func newChannelView(positionManager squirrel.PositionManager, channels *channels, ch *channel) *channelView {
+	v := &channelView{PositionManager: positionManager, channels: channels, channel: ch, pending: make(chan struct{}, 1)}
+	// PositionManager sends changes while holding its lock, so they're only
+	// recorded here; members are worked out and sent to Septembers of the
+	// channel from another routine, which may block on them.
+	changed := make(chan []int, 16)
+	positionManager.RegisterEnabledChanged(changed)
+	v.enabled = positionManager.Enabled()
+	go func() {
+		for enabled := range changed {
+			v.mu.Lock()
+			v.enabled = enabled
+			v.mu.Unlock()
+			v.notify()
+		}
+	}()
+	go v.notifyEnabledChanged()
+	return v
+}
+
+func (v *channelView) Get(index int) (pos squirrel.Position, err error) {
+	if !v.channels.isMember(v.channel, index) {
+		err = fmt.Errorf("node with index %d is not on channel %s", index, v.channel.name)
+		return
+	}
+	return v.PositionManager.Get(index)
+}
+
+func (v *channelView) GetAddr(hardAddr string) (pos squirrel.Position, err error) {
+	id, ok := v.channels.addrReverse.GetS(hardAddr)
+	if !ok {
+		err = fmt.Errorf("node with hardware address %s is not found", hardAddr)
+		return
+	}
+	return v.Get(id)
+}
+
+func (v *channelView) Distance(index1, index2 int) float64 {
+	if !v.channels.isMember(v.channel, index1) || !v.channels.isMember(v.channel, index2) {
+		return math.MaxFloat64
+	}
+	return v.PositionManager.Distance(index1, index2)
+}
+
+func (v *channelView) IsEnabled(index int) bool {
+	return v.channels.isMember(v.channel, index) && v.PositionManager.IsEnabled(index)
+}
+
+func (v *channelView) Enabled() []int {
+	return v.members(v.PositionManager.Enabled())
+}
+
+// members returns identities in all that are on the channel.
+func (v *channelView) members(all []int) []int {
+	e := make([]int, 0, len(all))
+	for _, id := range all {
+		if v.channels.isMember(v.channel, id) {
+			e = append(e, id)
+		}
+	}
+	return e
+}
+
+func (v *channelView) RegisterEnabledChanged(channel chan<- []int) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.enabledChanged = append(v.enabledChanged, channel)
+}
+
+// notify has enabled nodes of the channel sent to registered channels. It
+// never blocks; calls made before the previous one is handled are coalesced.
+func (v *channelView) notify() {
+	select {
+	case v.pending <- struct{}{}:
+	default:
+	}
+}
+
+func (v *channelView) notifyEnabledChanged() {
+	for range v.pending {
+		v.mu.Lock()
+		all, registered := v.enabled, v.enabledChanged
+		v.mu.Unlock()
+		for _, c := range registered {
+			c <- v.members(all)
+		}
+	}
+}
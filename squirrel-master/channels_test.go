@@ -0,0 +1,241 @@
+package main
+
+import (
+	"math"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coreos/go-etcd/etcd"
+	"github.com/squirrel-land/squirrel"
+)
+
+// reachSeptember decides upon broadcast frames for nodes, regardless of which
+// are enabled, and delivers frames to those in reach.
+type reachSeptember struct {
+	nodes []int
+	reach map[int]bool
+}
+
+func (s *reachSeptember) ParametersHelp() string                              { return "" }
+func (s *reachSeptember) Configure(*etcd.Node) error                          { return nil }
+func (s *reachSeptember) Initialize(positionManager squirrel.PositionManager) {}
+
+func (s *reachSeptember) SendUnicast(source int, destination int, frame *squirrel.Frame) squirrel.Result {
+	return squirrel.Result{Destination: destination, Delivered: s.reach[destination]}
+}
+
+func (s *reachSeptember) SendBroadcast(source int, frame *squirrel.Frame, underlying []squirrel.Result) []squirrel.Result {
+	results := underlying[:0]
+	for _, id := range s.nodes {
+		results = append(results, squirrel.Result{Destination: id, Delivered: s.reach[id]})
+	}
+	return results
+}
+
+func nodeMAC(identity int) net.HardwareAddr {
+	return net.HardwareAddr{0x02, 0, 0, 0, 0, byte(identity)}
+}
+
+// newTestChannels returns channels with the default channel and channel b,
+// and nodes 1 to 5 enabled. 1 is on both channels, 2 and 4 are on b, and the
+// rest are on the default channel.
+func newTestChannels(t *testing.T, a, b squirrel.SeptemberV2) (*channels, squirrel.PositionManager) {
+	addrReverse := newAddressReverse()
+	positionManager := NewPositionManager(8, addrReverse)
+	c := newChannels(positionManager, addrReverse)
+	for _, ch := range []struct {
+		name      string
+		september squirrel.SeptemberV2
+	}{{defaultChannel, a}, {"b", b}} {
+		if _, err := c.add(ch.name, ch.september); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for id := 1; id <= 5; id++ {
+		addrReverse.Add(nodeMAC(id), id)
+		positionManager.Enable(id)
+	}
+	c.Assign(dir("/channels", value("/channels/1", "default, b"), value("/channels/2", "b"), value("/channels/4", "b")))
+	return c, positionManager
+}
+
+func TestChannelsAssign(t *testing.T) {
+	c, _ := newTestChannels(t, new(reachSeptember), new(reachSeptember))
+	def, b := c.byName[defaultChannel], c.byName["b"]
+	expected := map[int][]*channel{1: {def, b}, 2: {b}, 3: {def}, 4: {b}, 5: {def}}
+	check := func() {
+		t.Helper()
+		for id, chs := range expected {
+			if got := c.of(id); !reflect.DeepEqual(got, chs) {
+				t.Errorf("node %d is on %d channels, expected %d", id, len(got), len(chs))
+			}
+		}
+	}
+	check()
+
+	// invalid assignments are ignored as a whole
+	c.Assign(dir("/channels", value("/channels/3", "b"), value("/channels/5", "c")))
+	check()
+	c.Assign(dir("/channels", value("/channels/3", "b"), value("/channels/node5", "b")))
+	check()
+
+	c.Assign(nil)
+	for id := 1; id <= 5; id++ {
+		if chs := c.of(id); len(chs) != 1 || chs[0] != def {
+			t.Errorf("node %d is not only on the default channel after assignment is removed", id)
+		}
+	}
+}
+
+func TestChannelsSendUnicast(t *testing.T) {
+	a := &reachSeptember{reach: map[int]bool{2: true, 3: true}}
+	b := &reachSeptember{reach: map[int]bool{2: true}}
+	c, _ := newTestChannels(t, a, b)
+	tests := []struct {
+		source, destination int
+		delivered           bool
+		reason              squirrel.DropReason
+	}{
+		{1, 2, true, 0},  // on b
+		{1, 3, true, 0},  // on the default channel
+		{1, 5, false, 0}, // out of reach on the default channel
+		{3, 2, false, squirrel.DroppedOutOfRange}, // no channel in common
+	}
+	for _, test := range tests {
+		r := c.SendUnicast(test.source, test.destination, nil)
+		if r.Delivered != test.delivered || (!r.Delivered && r.Reason != test.reason) {
+			t.Errorf("%d -> %d: got %+v, expected delivered %v", test.source, test.destination, r, test.delivered)
+		}
+	}
+}
+
+func TestChannelsSendBroadcast(t *testing.T) {
+	// 2 is not on the default channel and 5 is not on b, so what their
+	// Septembers decide for them is filtered out
+	a := &reachSeptember{nodes: []int{2, 3, 5}, reach: map[int]bool{2: true, 3: true}}
+	b := &reachSeptember{nodes: []int{2, 4, 5}, reach: map[int]bool{2: true, 5: true}}
+	c, _ := newTestChannels(t, a, b)
+	expected := map[int]bool{2: true, 3: true, 4: false, 5: false}
+	underlying := make([]squirrel.Result, 8)
+	// again, to check that merging leaves nothing behind for the next frame
+	for i := 0; i < 2; i++ {
+		got := make(map[int]bool)
+		for _, r := range c.SendBroadcast(1, nil, underlying) {
+			if _, ok := got[r.Destination]; ok {
+				t.Errorf("more than one result for node %d", r.Destination)
+			}
+			got[r.Destination] = r.Delivered
+		}
+		if !reflect.DeepEqual(got, expected) {
+			t.Errorf("got %v, expected %v", got, expected)
+		}
+	}
+
+	// a source on one channel only gets what its September decides, filtered
+	got := make(map[int]bool)
+	for _, r := range c.SendBroadcast(3, nil, underlying) {
+		got[r.Destination] = r.Delivered
+	}
+	if expected := map[int]bool{3: true, 5: false}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("got %v, expected %v", got, expected)
+	}
+}
+
+func TestChannelView(t *testing.T) {
+	c, positionManager := newTestChannels(t, new(reachSeptember), new(reachSeptember))
+	positionManager.Set(1, 0, 0, 0)
+	positionManager.Set(2, 3, 4, 0)
+	view := c.byName["b"].view
+
+	if enabled := view.Enabled(); !reflect.DeepEqual(enabled, []int{1, 2, 4}) {
+		t.Errorf("enabled nodes are %v, expected [1 2 4]", enabled)
+	}
+	if view.IsEnabled(3) || !view.IsEnabled(2) {
+		t.Error("IsEnabled doesn't follow channel assignment")
+	}
+	if d := view.Distance(1, 2); d != 5 {
+		t.Errorf("distance between 1 and 2 is %v, expected 5", d)
+	}
+	if d := view.Distance(1, 3); d != math.MaxFloat64 {
+		t.Errorf("distance to a node on another channel is %v", d)
+	}
+	if _, err := view.Get(3); err == nil {
+		t.Error("got position of a node on another channel")
+	}
+	if _, err := view.GetAddr(nodeMAC(3).String()); err == nil {
+		t.Error("got position of a node on another channel by its address")
+	}
+	if pos, err := view.GetAddr(nodeMAC(2).String()); err != nil || pos.X != 3 {
+		t.Errorf("got position %+v (%v) of node 2 by its address", pos, err)
+	}
+}
+
+func TestChannelViewEnabledChanged(t *testing.T) {
+	c, positionManager := newTestChannels(t, new(reachSeptember), new(reachSeptember))
+	changed := make(chan []int, 16)
+	c.byName["b"].view.RegisterEnabledChanged(changed)
+	expect := func(expected []int) {
+		t.Helper()
+		timeout := time.After(5 * time.Second)
+		for {
+			select {
+			case enabled := <-changed:
+				if reflect.DeepEqual(enabled, expected) {
+					return
+				}
+			case <-timeout:
+				t.Fatalf("enabled nodes never changed to %v", expected)
+			}
+		}
+	}
+
+	c.Assign(dir("/channels", value("/channels/1", "default, b"), value("/channels/2", "b"), value("/channels/3", "b"), value("/channels/4", "b")))
+	expect([]int{1, 2, 3, 4})
+	positionManager.Disable(4)
+	expect([]int{1, 2, 3})
+	positionManager.Enable(4)
+	expect([]int{1, 2, 3, 4})
+}
+
+// TestChannelViewNoDeadlock has nodes enabled and disabled while Septembers of
+// channels ask for enabled nodes whenever they change.
+func TestChannelViewNoDeadlock(t *testing.T) {
+	c, positionManager := newTestChannels(t, new(reachSeptember), new(reachSeptember))
+	for _, ch := range c.list {
+		changed := make(chan []int)
+		ch.view.RegisterEnabledChanged(changed)
+		go func(view *channelView) {
+			for range changed {
+				view.Enabled()
+			}
+		}(ch.view)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		var wg sync.WaitGroup
+		for id := 1; id <= 5; id++ {
+			wg.Add(1)
+			go func(id int) {
+				defer wg.Done()
+				for i := 0; i < 200; i++ {
+					positionManager.Disable(id)
+					positionManager.Enable(id)
+					if i%50 == 0 {
+						c.Assign(dir("/channels", value("/channels/1", "b")))
+					}
+				}
+			}(id)
+		}
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("enabling and disabling nodes deadlocked")
+	}
+}
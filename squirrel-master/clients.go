@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"github.com/squirrel-land/squirrel/common"
+)
+
+var addressPoolFull = errors.New("Adress poll is full")
+
+type client struct {
+	Link *common.Link
+	Addr net.HardwareAddr
+	Name string // can be empty
+}
+
+// clientRegistry maps identities to joined clients. It is read for every
+// frame, but only changes when a client joins or leaves; so reads are lock-free
+// on an immutable snapshot, and changes are made on a copy of the snapshot
+// that then replaces it. A reader may keep using a snapshot taken right before
+// a client left, so a client's Link should stay usable after it leaves.
+type clientRegistry struct {
+	snapshot atomic.Value // []*client, indexed by identity
+	mu       sync.Mutex   // serializes changes
+}
+
+func newClientRegistry(capacity int) *clientRegistry {
+	r := new(clientRegistry)
+	r.snapshot.Store(make([]*client, capacity))
+	return r
+}
+
+// Get returns the client with identity, or nil if there isn't one.
+func (r *clientRegistry) Get(identity int) *client {
+	clients := r.snapshot.Load().([]*client)
+	if identity < 0 || identity >= len(clients) {
+		return nil
+	}
+	return clients[identity]
+}
+
+// Add assigns c the lowest identity (starting from 1) that is not taken.
+func (r *clientRegistry) Add(c *client) (identity int, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	clients := r.snapshot.Load().([]*client)
+	for identity = 1; identity < len(clients); identity++ {
+		if clients[identity] == nil {
+			break
+		}
+	}
+	if identity == len(clients) {
+		return 0, addressPoolFull
+	}
+	updated := make([]*client, len(clients))
+	copy(updated, clients)
+	updated[identity] = c
+	r.snapshot.Store(updated)
+	return
+}
+
+// Remove removes and returns the client with identity.
+func (r *clientRegistry) Remove(identity int) (c *client) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	clients := r.snapshot.Load().([]*client)
+	c = clients[identity]
+	updated := make([]*client, len(clients))
+	copy(updated, clients)
+	updated[identity] = nil
+	r.snapshot.Store(updated)
+	return
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coreos/go-etcd/etcd"
+	"github.com/squirrel-land/squirrel"
+	"github.com/squirrel-land/squirrel/common"
+)
+
+func TestClientRegistryConcurrent(t *testing.T) {
+	const (
+		routines   = 8
+		iterations = 1000
+	)
+	r := newClientRegistry(routines + 1)
+	var wg sync.WaitGroup
+	for i := 0; i < routines; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < iterations; j++ {
+				c := new(client)
+				identity, err := r.Add(c)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				// the identity is ours until we remove it
+				if got := r.Get(identity); got != c {
+					t.Errorf("identity %d is %p, expected %p", identity, got, c)
+				}
+				for other := 0; other <= routines; other++ {
+					r.Get(other)
+				}
+				if got := r.Remove(identity); got != c {
+					t.Errorf("removed %p from identity %d, expected %p", got, identity, c)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	for identity := 0; identity <= routines; identity++ {
+		if c := r.Get(identity); c != nil {
+			t.Errorf("identity %d is still taken", identity)
+		}
+	}
+	if _, err := r.Add(new(client)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestClientRegistryFull(t *testing.T) {
+	r := newClientRegistry(3)
+	for expected := 1; expected < 3; expected++ {
+		if identity, err := r.Add(new(client)); err != nil || identity != expected {
+			t.Fatalf("got identity %d (%v), expected %d", identity, err, expected)
+		}
+	}
+	if _, err := r.Add(new(client)); err != addressPoolFull {
+		t.Errorf("expected addressPoolFull, got %v", err)
+	}
+	r.Remove(1)
+	if identity, err := r.Add(new(client)); err != nil || identity != 1 {
+		t.Errorf("got identity %d (%v), expected 1", identity, err)
+	}
+}
+
+type stillMobility struct{}
+
+func (stillMobility) ParametersHelp() string                              { return "" }
+func (stillMobility) Configure(*etcd.Node) error                          { return nil }
+func (stillMobility) Initialize(positionManager squirrel.PositionManager) {}
+
+// deliverEverywhere is a September that delivers every frame to every enabled
+// node.
+type deliverEverywhere struct {
+	positionManager squirrel.PositionManager
+}
+
+func (d *deliverEverywhere) ParametersHelp() string     { return "" }
+func (d *deliverEverywhere) Configure(*etcd.Node) error { return nil }
+
+func (d *deliverEverywhere) Initialize(positionManager squirrel.PositionManager) {
+	d.positionManager = positionManager
+}
+
+func (d *deliverEverywhere) SendUnicast(source int, destination int, frame *squirrel.Frame) squirrel.Result {
+	return squirrel.Result{Destination: destination, Delivered: true}
+}
+
+func (d *deliverEverywhere) SendBroadcast(source int, frame *squirrel.Frame, underlying []squirrel.Result) []squirrel.Result {
+	results := underlying[:0]
+	for _, id := range d.positionManager.Enabled() {
+		if id != source {
+			results = append(results, squirrel.Result{Destination: id, Delivered: true})
+		}
+	}
+	return results
+}
+
+// TestJoinLeaveStress has clients repeatedly join, send frames to each other
+// and leave, and checks nothing of them is left behind. It's mostly useful
+// with -race.
+// pipeListener is a net.Listener that hands master one end of each
+// connection dialed with Dial.
+type pipeListener struct {
+	conns  chan net.Conn
+	closed chan struct{}
+	once   sync.Once
+}
+
+func newPipeListener() *pipeListener {
+	return &pipeListener{conns: make(chan net.Conn), closed: make(chan struct{})}
+}
+
+func (l *pipeListener) Dial() net.Conn {
+	conn, peer := net.Pipe()
+	l.conns <- conn
+	return peer
+}
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, errors.New("listener closed")
+	}
+}
+
+func (l *pipeListener) Close() error {
+	l.once.Do(func() { close(l.closed) })
+	return nil
+}
+
+func (l *pipeListener) Addr() net.Addr { return pipeAddr{} }
+
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string { return "pipe" }
+func (pipeAddr) String() string  { return "pipe" }
+
+func TestJoinLeaveStress(t *testing.T) {
+	const (
+		workers = 6
+		rounds  = 30
+		frames  = 20
+	)
+	_, network, _ := net.ParseCIDR("10.0.0.0/24")
+	master := NewMaster(network, 1, stillMobility{}, &deliverEverywhere{})
+	mac := func(i int) net.HardwareAddr { return net.HardwareAddr{0x02, 0, 0, 0, 0, byte(i + 1)} }
+
+	listener := newPipeListener()
+	defer listener.Close()
+	go func() {
+		for {
+			if err := master.accept(listener); err != nil {
+				select {
+				case <-listener.closed:
+					return
+				default:
+					t.Error(err)
+				}
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for round := 0; round < rounds; round++ {
+				conn := listener.Dial()
+				worker := common.NewLink(conn)
+				if err := worker.SendJoinReq(&common.JoinReq{MACAddr: mac(i), Name: fmt.Sprint("node", i)}); err != nil {
+					t.Error(err)
+					return
+				}
+				rsp, err := worker.GetJoinRsp()
+				if err == nil && rsp.Error != nil {
+					err = rsp.Error
+				}
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				identity, err := master.addressPool.GetIdentity(rsp.Address)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				worker.StartRoutines()
+				go func() {
+					for {
+						buf, ok := worker.ReadFrame()
+						if !ok {
+							return
+						}
+						buf.Done()
+					}
+				}()
+				pool := common.NewSlicePool(1600)
+				var written sync.WaitGroup
+				for f := 0; f < frames; f++ {
+					destination := net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+					if f%2 == 1 {
+						destination = mac((i + f) % workers)
+					}
+					buf := pool.Get()
+					*buf.SlicePtr() = common.AppendEncodedFrame(buf.Slice()[:0], ethernetFrame(destination, 0x0800, make([]byte, 46)))
+					written.Add(1)
+					worker.WriteEncodedFrameNotify(buf, func(error) { written.Done() })
+				}
+				written.Wait()
+				worker.Done()
+				conn.Close()
+
+				// master frees the identity last when the client leaves
+				deadline := time.Now().Add(5 * time.Second)
+				for master.clients.Get(identity) != nil {
+					if time.Now().After(deadline) {
+						t.Errorf("node%d never left", i)
+						return
+					}
+					time.Sleep(time.Millisecond)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for identity := 0; identity <= master.addressPool.Capacity(); identity++ {
+		if master.clients.Get(identity) != nil {
+			t.Errorf("identity %d is still taken", identity)
+		}
+	}
+	if enabled := master.positionManager.Enabled(); len(enabled) != 0 {
+		t.Errorf("nodes %v are still enabled", enabled)
+	}
+	master.addrReverse.RLock()
+	defer master.addrReverse.RUnlock()
+	if len(master.addrReverse.addrs) != 0 || len(master.addrReverse.names) != 0 {
+		t.Errorf("addresses %v and names %v are still mapped", master.addrReverse.addrs, master.addrReverse.names)
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"time"
+)
+
+// dilatedClock implements squirrel.Clock. Emulated time starts at the wall
+// clock time the clock is created, and runs factor times slower from there.
+type dilatedClock struct {
+	origin time.Time
+	factor float64
+}
+
+func newDilatedClock(factor float64) *dilatedClock {
+	return &dilatedClock{origin: time.Now(), factor: factor}
+}
+
+// wall converts a duration of emulated time into wall clock time.
+func (c *dilatedClock) wall(d time.Duration) time.Duration {
+	return time.Duration(float64(d) * c.factor)
+}
+
+func (c *dilatedClock) Now() time.Time {
+	return c.origin.Add(time.Duration(float64(time.Since(c.origin)) / c.factor))
+}
+
+func (c *dilatedClock) Since(t time.Time) time.Duration {
+	return c.Now().Sub(t)
+}
+
+func (c *dilatedClock) Sleep(d time.Duration) {
+	time.Sleep(c.wall(d))
+}
+
+func (c *dilatedClock) After(d time.Duration) <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	time.AfterFunc(c.wall(d), func() {
+		ch <- c.Now()
+	})
+	return ch
+}
+
+func (c *dilatedClock) NewTicker(d time.Duration) *time.Ticker {
+	return time.NewTicker(c.wall(d))
+}
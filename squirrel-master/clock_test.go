@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDilatedClockWall(t *testing.T) {
+	tests := []struct {
+		factor   float64
+		emulated time.Duration
+		expected time.Duration
+	}{
+		{1, time.Second, time.Second},
+		{10, time.Second, 10 * time.Second},
+		{0.5, time.Second, 500 * time.Millisecond},
+	}
+	for _, test := range tests {
+		if got := newDilatedClock(test.factor).wall(test.emulated); got != test.expected {
+			t.Errorf("factor %v: %v is %v of wall clock time, expected %v", test.factor, test.emulated, got, test.expected)
+		}
+	}
+}
+
+func TestDilatedClockNow(t *testing.T) {
+	c := newDilatedClock(10)
+	start := time.Now()
+	emulatedStart := c.Now()
+	time.Sleep(100 * time.Millisecond)
+	wall := time.Since(start)
+	emulated := c.Since(emulatedStart)
+	// emulated time runs 10 times slower, give or take scheduling
+	if emulated < wall/10-2*time.Millisecond || emulated > wall/10+2*time.Millisecond {
+		t.Errorf("%v of emulated time elapsed in %v, expected about %v", emulated, wall, wall/10)
+	}
+	if c.Now().Before(emulatedStart) {
+		t.Error("emulated time went backwards")
+	}
+}
+
+func TestDilatedClockAfter(t *testing.T) {
+	c := newDilatedClock(4)
+	start := time.Now()
+	emulatedStart := c.Now()
+	at := <-c.After(10 * time.Millisecond)
+	if wall := time.Since(start); wall < 40*time.Millisecond {
+		t.Errorf("10ms of emulated time elapsed after only %v of wall clock time", wall)
+	}
+	if at.Sub(emulatedStart) < 10*time.Millisecond {
+		t.Errorf("After sent %v, less than 10ms after it was called", at.Sub(emulatedStart))
+	}
+
+	start = time.Now()
+	c.Sleep(5 * time.Millisecond)
+	if wall := time.Since(start); wall < 20*time.Millisecond {
+		t.Errorf("slept %v of wall clock time, expected at least 20ms", wall)
+	}
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/squirrel-land/squirrel"
+)
+
+// Decisions of September are recorded one per line, in one of the forms:
+//
+//	<elapsed ns> U <source> <destination> <size> <1 if delivered, 0 otherwise>
+//	<elapsed ns> B <source> <size> <comma separated recipients, or - if none>
+//
+// where elapsed is the emulated time since recording started.
+
+// recordingSeptember wraps a decider, and records every decision it makes
+// into w.
+type recordingSeptember struct {
+	decider
+	out    io.Writer
+	w      *bufio.Writer
+	clock  squirrel.Clock
+	start  time.Time
+	ticker *time.Ticker
+	done   chan struct{}
+	mu     sync.Mutex
+}
+
+func newRecordingSeptember(d decider, w io.Writer, clock squirrel.Clock) *recordingSeptember {
+	r := &recordingSeptember{
+		decider: d,
+		out:     w,
+		w:       bufio.NewWriter(w),
+		clock:   clock,
+		start:   clock.Now(),
+		ticker:  time.NewTicker(time.Second),
+		done:    make(chan struct{}),
+	}
+	go func() {
+		for {
+			select {
+			case <-r.ticker.C:
+			case <-r.done:
+				return
+			}
+			r.mu.Lock()
+			if !r.closed() {
+				if err := r.w.Flush(); err != nil {
+					log.Printf("writing decisions error: %v\n", err)
+				}
+			}
+			r.mu.Unlock()
+		}
+	}()
+	return r
+}
+
+// Close flushes recorded decisions, and closes the underlying writer if it's
+// an io.Closer. Decisions made after Close are not recorded.
+func (r *recordingSeptember) Close() (err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed() {
+		return nil
+	}
+	r.ticker.Stop()
+	close(r.done)
+	err = r.w.Flush()
+	if c, ok := r.out.(io.Closer); ok {
+		if cerr := c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return
+}
+
+// closed reports whether r has been closed. r.mu must be held.
+func (r *recordingSeptember) closed() bool {
+	select {
+	case <-r.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (r *recordingSeptember) SendUnicast(source int, destination int, frame *squirrel.Frame) squirrel.Result {
+	result := r.decider.SendUnicast(source, destination, frame)
+	outcome := 0
+	if result.Delivered {
+		outcome = 1
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed() {
+		return result
+	}
+	fmt.Fprintf(r.w, "%d U %d %d %d %d\n", r.clock.Since(r.start), source, destination, frame.Meta.PayloadSize, outcome)
+	return result
+}
+
+func (r *recordingSeptember) SendBroadcast(source int, frame *squirrel.Frame, underlying []squirrel.Result) []squirrel.Result {
+	results := r.decider.SendBroadcast(source, frame, underlying)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed() {
+		return results
+	}
+	fmt.Fprintf(r.w, "%d B %d %d ", r.clock.Since(r.start), source, frame.Meta.PayloadSize)
+	n := 0
+	for _, result := range results {
+		if !result.Delivered {
+			continue
+		}
+		if n > 0 {
+			r.w.WriteByte(',')
+		}
+		r.w.WriteString(strconv.Itoa(result.Destination))
+		n++
+	}
+	if n == 0 {
+		r.w.WriteByte('-')
+	}
+	r.w.WriteByte('\n')
+	return results
+}
+
+type decision struct {
+	broadcast   bool
+	destination int
+	size        int
+	delivered   bool
+	recipients  []int
+}
+
+// replayingSeptember wraps a decider, but instead of asking it, makes
+// decisions as recorded by recordingSeptember. Only whether frames are
+// delivered is recorded, so dropped frames have DroppedUnknown as reason.
+// Recorded decisions are matched
+// to frames by order per source. If a frame doesn't match the next recorded
+// decision for its source, e.g. because the run diverged from the recorded one,
+// it is dropped and the recorded decision is kept for following frames.
+type replayingSeptember struct {
+	decider
+	decisions map[int][]*decision
+	mu        sync.Mutex
+}
+
+func newReplayingSeptember(d decider, r io.Reader) (ret *replayingSeptember, err error) {
+	ret = &replayingSeptember{decider: d, decisions: make(map[int][]*decision)}
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		var (
+			source int
+			d      *decision
+		)
+		if source, d, err = parseDecision(scanner.Text()); err != nil {
+			err = fmt.Errorf("line %d: %v", line, err)
+			return
+		}
+		ret.decisions[source] = append(ret.decisions[source], d)
+	}
+	err = scanner.Err()
+	return
+}
+
+func parseDecision(line string) (source int, d *decision, err error) {
+	fields := strings.Fields(line)
+	d = new(decision)
+	switch {
+	case len(fields) == 6 && fields[1] == "U":
+		if source, err = strconv.Atoi(fields[2]); err != nil {
+			return
+		}
+		if d.destination, err = strconv.Atoi(fields[3]); err != nil {
+			return
+		}
+		if d.size, err = strconv.Atoi(fields[4]); err != nil {
+			return
+		}
+		d.delivered = fields[5] == "1"
+	case len(fields) == 5 && fields[1] == "B":
+		d.broadcast = true
+		if source, err = strconv.Atoi(fields[2]); err != nil {
+			return
+		}
+		if d.size, err = strconv.Atoi(fields[3]); err != nil {
+			return
+		}
+		if fields[4] != "-" {
+			for _, s := range strings.Split(fields[4], ",") {
+				var id int
+				if id, err = strconv.Atoi(s); err != nil {
+					return
+				}
+				d.recipients = append(d.recipients, id)
+			}
+		}
+	default:
+		err = fmt.Errorf("malformed decision: %q", line)
+	}
+	return
+}
+
+// next pops the next recorded decision for source if it matches.
+func (r *replayingSeptember) next(source int, broadcast bool, destination int, size int) *decision {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	queue := r.decisions[source]
+	if len(queue) == 0 {
+		if *debug {
+			log.Printf("no recorded decision left for frame from client %d\n", source)
+		}
+		return nil
+	}
+	d := queue[0]
+	if d.broadcast != broadcast || d.destination != destination || d.size != size {
+		if *debug {
+			log.Printf("frame of length %d from client %d doesn't match recorded decision\n", size, source)
+		}
+		return nil
+	}
+	r.decisions[source] = queue[1:]
+	return d
+}
+
+func (r *replayingSeptember) SendUnicast(source int, destination int, frame *squirrel.Frame) squirrel.Result {
+	if d := r.next(source, false, destination, frame.Meta.PayloadSize); d != nil && d.delivered {
+		return squirrel.Result{Destination: destination, Delivered: true, SINR: math.NaN()}
+	}
+	return squirrel.Result{Destination: destination, Reason: squirrel.DroppedUnknown, SINR: math.NaN()}
+}
+
+func (r *replayingSeptember) SendBroadcast(source int, frame *squirrel.Frame, underlying []squirrel.Result) []squirrel.Result {
+	d := r.next(source, true, 0, frame.Meta.PayloadSize)
+	if d == nil {
+		return underlying[:0]
+	}
+	results := underlying[:0]
+	for _, id := range d.recipients {
+		results = append(results, squirrel.Result{Destination: id, Delivered: true, SINR: math.NaN()})
+	}
+	return results
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/squirrel-land/squirrel"
+)
+
+func TestParseDecision(t *testing.T) {
+	tests := []struct {
+		line     string
+		source   int
+		expected *decision
+		err      bool
+	}{
+		{line: "120 U 3 7 1500 1", source: 3, expected: &decision{destination: 7, size: 1500, delivered: true}},
+		{line: "120 U 3 7 1500 0", source: 3, expected: &decision{destination: 7, size: 1500}},
+		{line: "5 B 2 60 1,4,9", source: 2, expected: &decision{broadcast: true, size: 60, recipients: []int{1, 4, 9}}},
+		{line: "5 B 2 60 -", source: 2, expected: &decision{broadcast: true, size: 60}},
+		{line: "5 B 2 60 1,x", err: true},
+		{line: "120 U a 7 1500 1", err: true},
+		{line: "120 U 3 7 1500", err: true},
+		{line: "120 X 3 7 1500 1", err: true},
+		{line: "", err: true},
+	}
+	for _, test := range tests {
+		source, d, err := parseDecision(test.line)
+		if test.err {
+			if err == nil {
+				t.Errorf("%q: expected an error", test.line)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", test.line, err)
+			continue
+		}
+		if source != test.source || !reflect.DeepEqual(d, test.expected) {
+			t.Errorf("%q: got %d %+v, expected %d %+v", test.line, source, d, test.source, test.expected)
+		}
+	}
+}
+
+func TestReplayMoreRecipientsThanUnderlying(t *testing.T) {
+	r, err := newReplayingSeptember(nil, bytes.NewBufferString("0 B 1 10 2,3,4\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame := &squirrel.Frame{Meta: squirrel.FrameMeta{PayloadSize: 10}}
+	results := r.SendBroadcast(1, frame, make([]squirrel.Result, 0, 1))
+	if len(results) != 3 || results[2].Destination != 4 || !results[2].Delivered {
+		t.Errorf("results are %+v", results)
+	}
+}
+
+type deliverAll struct{}
+
+func (deliverAll) SendUnicast(source int, destination int, frame *squirrel.Frame) squirrel.Result {
+	return squirrel.Result{Destination: destination, Delivered: true}
+}
+
+func (deliverAll) SendBroadcast(source int, frame *squirrel.Frame, underlying []squirrel.Result) []squirrel.Result {
+	return append(underlying[:0], squirrel.Result{Destination: 2, Delivered: true})
+}
+
+type closeBuffer struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (b *closeBuffer) Close() error {
+	b.closed = true
+	return nil
+}
+
+func TestRecordingClose(t *testing.T) {
+	var buf closeBuffer
+	r := newRecordingSeptember(deliverAll{}, &buf, newDilatedClock(1))
+	frame := &squirrel.Frame{Meta: squirrel.FrameMeta{PayloadSize: 10}}
+	r.SendUnicast(1, 2, frame)
+	r.SendBroadcast(1, frame, nil)
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !buf.closed {
+		t.Error("writer is not closed")
+	}
+	r.SendUnicast(1, 2, frame)
+	replayed, err := newReplayingSeptember(nil, &buf.Buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := len(replayed.decisions[1]); got != 2 {
+		t.Errorf("%d decisions recorded, expected 2", got)
+	}
+}
@@ -5,10 +5,12 @@ import (
 
 	"github.com/squirrel-land/models"
 	"github.com/squirrel-land/squirrel"
+	"github.com/squirrel-land/squirrel/septembers"
 )
 
 var (
-	notRegistered = errors.New("MobilityManager or September is not registered.")
+	notRegistered            = errors.New("MobilityManager or September is not registered.")
+	interceptorNotRegistered = errors.New("FrameInterceptor is not registered.")
 )
 
 func newMobilityManager(name string) (mobilityManager squirrel.MobilityManager, err error) {
@@ -20,11 +22,36 @@ func newMobilityManager(name string) (mobilityManager squirrel.MobilityManager,
 	return
 }
 
-func newSeptember(name string) (september squirrel.September, err error) {
+// septembersV2 has constructors of SeptemberV2 implementations, by name. They
+// take precedence over Septembers of the same name in models.
+var septembersV2 = make(map[string]func() squirrel.SeptemberV2)
+
+func init() {
+	septembersV2["composite"] = func() squirrel.SeptemberV2 { return septembers.NewComposite(newSeptember) }
+	septembersV2["rx_power"] = func() squirrel.SeptemberV2 { return septembers.NewRxPower() }
+	septembersV2["trace"] = func() squirrel.SeptemberV2 { return septembers.NewTrace() }
+	septembersV2["gilbert_elliott"] = func() squirrel.SeptemberV2 { return septembers.NewGilbertElliott() }
+	septembersV2["link_matrix"] = func() squirrel.SeptemberV2 { return septembers.NewLinkMatrix() }
+}
+
+func newSeptember(name string) (september squirrel.SeptemberV2, err error) {
+	if constructor := septembersV2[name]; constructor != nil {
+		return constructor(), nil
+	}
 	constructor := models.Septembers[name]
 	if constructor == nil {
 		return nil, notRegistered
 	}
-	september = constructor()
+	september = squirrel.AdaptSeptember(constructor())
+	return
+}
+
+// newFrameInterceptor returns a new FrameInterceptor registered as name with
+// squirrel.RegisterFrameInterceptor.
+func newFrameInterceptor(name string) (interceptor squirrel.FrameInterceptor, err error) {
+	interceptor = squirrel.NewFrameInterceptor(name)
+	if interceptor == nil {
+		return nil, interceptorNotRegistered
+	}
 	return
 }
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/squirrel-land/squirrel"
+)
+
+func init() {
+	squirrel.RegisterFrameInterceptor("test_factory", func() squirrel.FrameInterceptor { return new(funcInterceptor) })
+}
+
+func TestNewFrameInterceptor(t *testing.T) {
+	if interceptor, err := newFrameInterceptor("test_factory"); err != nil || interceptor == nil {
+		t.Errorf("got %v (%v) for a registered interceptor", interceptor, err)
+	}
+	if _, err := newFrameInterceptor("test_unknown"); err != interceptorNotRegistered {
+		t.Errorf("got error %v for an interceptor that is not registered", err)
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-etcd/etcd"
+)
+
+// impairment holds parameters of artifacts applied to frames on a link after
+// September decides they should be delivered.
+type impairment struct {
+	BitErrorRate float64
+
+	// DuplicateProbability is the probability of a frame being delivered
+	// twice.
+	DuplicateProbability float64
+
+	// ReorderProbability is the probability of a frame being held back for a
+	// random delay uniformly distributed in (0, ReorderDelay], so that frames
+	// after it can overtake it.
+	ReorderProbability float64
+	ReorderDelay       time.Duration
+}
+
+// impairments holds the global impairment and per-link overrides. Per-link
+// overrides inherit any parameter they don't set from the global one.
+type impairments struct {
+	global impairment
+	links  map[linkKey]*impairment
+}
+
+func newImpairments() *impairments {
+	return &impairments{links: make(map[linkKey]*impairment)}
+}
+
+// parseImpairments parses a configuration dir like:
+//
+//	bit_error_rate                   -> 1e-6
+//	duplicate_probability            -> 0.01
+//	reorder_probability              -> 0.05
+//	reorder_delay_ms                 -> 20
+//	links/3-7/bit_error_rate         -> 1e-4
+//	links/3-7/duplicate_probability  -> 0.1
+//
+// where 3-7 is the directed link from identity 3 to identity 7.
+func parseImpairments(dir *etcd.Node) (ret *impairments, err error) {
+	ret = newImpairments()
+	if dir == nil {
+		return
+	}
+	if err = ret.global.parse(dir); err != nil {
+		return
+	}
+	for _, child := range dir.Nodes {
+		if path.Base(child.Key) != "links" || !child.Dir {
+			continue
+		}
+		for _, linkNode := range child.Nodes {
+			var key linkKey
+			if key, err = parseLinkKey(path.Base(linkNode.Key)); err != nil {
+				return
+			}
+			imp := ret.global
+			if err = imp.parse(linkNode); err != nil {
+				return
+			}
+			ret.links[key] = &imp
+		}
+	}
+	return
+}
+
+func parseLinkKey(s string) (key linkKey, err error) {
+	ids := strings.Split(s, "-")
+	if len(ids) != 2 {
+		err = fmt.Errorf("invalid link %q (expected <source>-<destination>)", s)
+		return
+	}
+	if key.Source, err = strconv.Atoi(ids[0]); err != nil {
+		return
+	}
+	key.Destination, err = strconv.Atoi(ids[1])
+	return
+}
+
+func parseProbability(node *etcd.Node) (p float64, err error) {
+	p, err = strconv.ParseFloat(node.Value, 64)
+	if err != nil {
+		return
+	}
+	if p < 0 || p > 1 {
+		err = fmt.Errorf("%s should be between 0 and 1, got %v", node.Key, p)
+	}
+	return
+}
+
+func (imp *impairment) parse(dir *etcd.Node) (err error) {
+	for _, node := range dir.Nodes {
+		if node.Dir {
+			continue
+		}
+		switch path.Base(node.Key) {
+		case "bit_error_rate":
+			imp.BitErrorRate, err = parseProbability(node)
+		case "duplicate_probability":
+			imp.DuplicateProbability, err = parseProbability(node)
+		case "reorder_probability":
+			imp.ReorderProbability, err = parseProbability(node)
+		case "reorder_delay_ms":
+			var ms float64
+			if ms, err = strconv.ParseFloat(node.Value, 64); err == nil {
+				imp.ReorderDelay = time.Duration(ms * float64(time.Millisecond))
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// holdBack returns how long a frame should be held back before being written to
+// the destination, or 0 if it should be written immediately.
+func (imp *impairment) holdBack(rng *rand.Rand) time.Duration {
+	if imp.ReorderDelay <= 0 || rng.Float64() >= imp.ReorderProbability {
+		return 0
+	}
+	return time.Duration(rng.Int63n(int64(imp.ReorderDelay))) + 1
+}
+
+func (i *impairments) get(source, destination int) *impairment {
+	if imp, ok := i.links[linkKey{Source: source, Destination: destination}]; ok {
+		return imp
+	}
+	return &i.global
+}
+
+// nextBitError returns the number of bits that pass intact before the next
+// flipped one, when each bit is flipped independently with probability ber.
+func nextBitError(ber float64, rng *rand.Rand) int {
+	if ber >= 1 {
+		return 0
+	}
+	skip := math.Floor(math.Log(1-rng.Float64()) / math.Log1p(-ber))
+	if skip > math.MaxInt32 {
+		return math.MaxInt32
+	}
+	return int(skip)
+}
+
+// corrupt flips bits in data independently with probability ber, starting
+// from bit offset first which is the position of the first bit error, as
+// returned by nextBitError.
+func corrupt(data []byte, first int, ber float64, rng *rand.Rand) {
+	for bit := first; bit < len(data)*8; bit += nextBitError(ber, rng) + 1 {
+		data[bit/8] ^= 0x80 >> uint(bit%8)
+	}
+}
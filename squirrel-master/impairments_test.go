@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/coreos/go-etcd/etcd"
+)
+
+func dir(key string, nodes ...*etcd.Node) *etcd.Node {
+	return &etcd.Node{Key: key, Dir: true, Nodes: nodes}
+}
+
+func value(key, v string) *etcd.Node {
+	return &etcd.Node{Key: key, Value: v}
+}
+
+func TestParseImpairments(t *testing.T) {
+	tests := []struct {
+		name   string
+		dir    *etcd.Node
+		global impairment
+		links  map[linkKey]impairment
+		err    bool
+	}{
+		{name: "nil", dir: nil},
+		{
+			name: "global",
+			dir: dir("/i",
+				value("/i/bit_error_rate", "1e-6"),
+				value("/i/duplicate_probability", "0.01"),
+				value("/i/reorder_probability", "0.05"),
+				value("/i/reorder_delay_ms", "20"),
+			),
+			global: impairment{BitErrorRate: 1e-6, DuplicateProbability: 0.01, ReorderProbability: 0.05, ReorderDelay: 20 * time.Millisecond},
+		},
+		{
+			name: "link inherits global",
+			dir: dir("/i",
+				value("/i/bit_error_rate", "1e-6"),
+				value("/i/duplicate_probability", "0.01"),
+				dir("/i/links", dir("/i/links/3-7", value("/i/links/3-7/bit_error_rate", "1e-4"))),
+			),
+			global: impairment{BitErrorRate: 1e-6, DuplicateProbability: 0.01},
+			links: map[linkKey]impairment{
+				{Source: 3, Destination: 7}: {BitErrorRate: 1e-4, DuplicateProbability: 0.01},
+			},
+		},
+		{name: "probability out of range", dir: dir("/i", value("/i/duplicate_probability", "1.5")), err: true},
+		{name: "not a number", dir: dir("/i", value("/i/bit_error_rate", "x")), err: true},
+		{name: "invalid link", dir: dir("/i", dir("/i/links", dir("/i/links/3"))), err: true},
+		{name: "invalid identity", dir: dir("/i", dir("/i/links", dir("/i/links/a-7"))), err: true},
+	}
+	for _, test := range tests {
+		ret, err := parseImpairments(test.dir)
+		if test.err {
+			if err == nil {
+				t.Errorf("%s: expected an error", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if ret.global != test.global {
+			t.Errorf("%s: global is %+v, expected %+v", test.name, ret.global, test.global)
+		}
+		if len(ret.links) != len(test.links) {
+			t.Errorf("%s: %d links, expected %d", test.name, len(ret.links), len(test.links))
+		}
+		for key, expected := range test.links {
+			if got := ret.get(key.Source, key.Destination); *got != expected {
+				t.Errorf("%s: link %v is %+v, expected %+v", test.name, key, *got, expected)
+			}
+		}
+	}
+}
+
+func TestCorrupt(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     []byte
+		first    int
+		ber      float64
+		expected []byte
+	}{
+		{name: "every bit", data: []byte{0x00, 0xff}, first: 0, ber: 1, expected: []byte{0xff, 0x00}},
+		{name: "from offset", data: []byte{0x00, 0x00}, first: 4, ber: 1, expected: []byte{0x0f, 0xff}},
+		{name: "past the end", data: []byte{0x00, 0x00}, first: 16, ber: 1, expected: []byte{0x00, 0x00}},
+		{name: "first bit only", data: []byte{0x00, 0x00}, first: 0, ber: 1e-300, expected: []byte{0x80, 0x00}},
+	}
+	for _, test := range tests {
+		corrupt(test.data, test.first, test.ber, rand.New(rand.NewSource(1)))
+		if !bytes.Equal(test.data, test.expected) {
+			t.Errorf("%s: got %x, expected %x", test.name, test.data, test.expected)
+		}
+	}
+}
+
+func TestCorruptRate(t *testing.T) {
+	const ber = 0.01
+	rng := rand.New(rand.NewSource(1))
+	data := make([]byte, 1<<17)
+	corrupt(data, nextBitError(ber, rng), ber, rng)
+	flipped := 0
+	for _, b := range data {
+		for ; b != 0; b &= b - 1 {
+			flipped++
+		}
+	}
+	expected := ber * float64(len(data)*8)
+	// within 5 standard deviations
+	if math.Abs(float64(flipped)-expected) > 5*math.Sqrt(expected*(1-ber)) {
+		t.Errorf("%d bits flipped, expected about %v", flipped, expected)
+	}
+}
@@ -7,7 +7,12 @@ import (
 	"log"
 	"net"
 	"os"
+	"os/signal"
+	"path"
 	"runtime/pprof"
+	"sort"
+	"strconv"
+	"syscall"
 	"time"
 
 	"github.com/coreos/go-etcd/etcd"
@@ -16,13 +21,36 @@ import (
 	"github.com/squirrel-land/squirrel/common"
 )
 
+type channelConfig struct {
+	name            string
+	september       string
+	septemberConfig *etcd.Node
+}
+
+type interceptorConfig struct {
+	interceptor       string
+	interceptorConfig *etcd.Node
+}
+
 type config struct {
+	etcdClient            *etcd.Client
 	uri                   string
 	emulatedSubnet        string
 	mobilityManager       string
 	mobilityManagerConfig *etcd.Node
 	september             string
 	septemberConfig       *etcd.Node
+	channels              []channelConfig
+	interceptors          []interceptorConfig
+	impairments           *etcd.Node
+	multicastSnooping     bool
+	unknownUnicast        string
+	unknownUnicastNode    int
+	dropWhenQueueFull     bool
+	serializeMedium       bool
+	mediumWindow          time.Duration
+	seed                  *int64
+	timeDilation          float64
 }
 
 func getConfig() (conf config, err error) {
@@ -31,6 +59,7 @@ func getConfig() (conf config, err error) {
 		endpoint = "http://127.0.0.1:4001"
 	}
 	client := etcd.NewClient([]string{endpoint})
+	conf.etcdClient = client
 
 	var ifce string
 	ifce, err = common.GetEtcdValue(client, "/squirrel/master_ifce")
@@ -111,6 +140,223 @@ func getConfig() (conf config, err error) {
 		conf.septemberConfig = resp.Node
 	}
 
+	conf.channels, err = getChannelConfigs(client)
+	if err != nil {
+		return
+	}
+
+	conf.interceptors, err = getInterceptorConfigs(client)
+	if err != nil {
+		return
+	}
+
+	conf.impairments, err = common.GetEtcdDir(client, "/squirrel/master/impairments")
+	if err != nil {
+		return
+	}
+
+	conf.multicastSnooping = true
+	var multicastSnooping string
+	multicastSnooping, err = common.GetEtcdValue(client, "/squirrel/master/multicast_snooping")
+	if err != nil {
+		if common.IsEtcdNotFoundError(err) {
+			err = nil
+		} else {
+			return
+		}
+	} else {
+		conf.multicastSnooping, err = strconv.ParseBool(multicastSnooping)
+		if err != nil {
+			return
+		}
+	}
+
+	conf.unknownUnicast, err = common.GetEtcdValue(client, "/squirrel/master/unknown_unicast")
+	if err != nil {
+		if common.IsEtcdNotFoundError(err) {
+			conf.unknownUnicast = "drop"
+			err = nil
+		} else {
+			return
+		}
+	}
+	if conf.unknownUnicast == "deliver" {
+		var unknownUnicastNode string
+		unknownUnicastNode, err = common.GetEtcdValue(client, "/squirrel/master/unknown_unicast_node")
+		if err != nil {
+			return
+		}
+		conf.unknownUnicastNode, err = strconv.Atoi(unknownUnicastNode)
+		if err != nil {
+			return
+		}
+	}
+
+	var dropWhenQueueFull string
+	dropWhenQueueFull, err = common.GetEtcdValue(client, "/squirrel/master/drop_when_queue_full")
+	if err != nil {
+		if common.IsEtcdNotFoundError(err) {
+			err = nil
+		} else {
+			return
+		}
+	} else {
+		conf.dropWhenQueueFull, err = strconv.ParseBool(dropWhenQueueFull)
+		if err != nil {
+			return
+		}
+	}
+
+	var serializeMedium string
+	serializeMedium, err = common.GetEtcdValue(client, "/squirrel/master/serialize_medium")
+	if err != nil {
+		if common.IsEtcdNotFoundError(err) {
+			err = nil
+		} else {
+			return
+		}
+	} else {
+		conf.serializeMedium, err = strconv.ParseBool(serializeMedium)
+		if err != nil {
+			return
+		}
+	}
+
+	var mediumWindow string
+	mediumWindow, err = common.GetEtcdValue(client, "/squirrel/master/medium_window_us")
+	if err != nil {
+		if common.IsEtcdNotFoundError(err) {
+			err = nil
+		} else {
+			return
+		}
+	} else {
+		var us int
+		us, err = strconv.Atoi(mediumWindow)
+		if err != nil {
+			return
+		}
+		conf.mediumWindow = time.Duration(us) * time.Microsecond
+	}
+
+	conf.timeDilation = 1
+	var timeDilation string
+	timeDilation, err = common.GetEtcdValue(client, "/squirrel/master/time_dilation")
+	if err != nil {
+		if common.IsEtcdNotFoundError(err) {
+			err = nil
+		} else {
+			return
+		}
+	} else {
+		conf.timeDilation, err = strconv.ParseFloat(timeDilation, 64)
+		if err != nil {
+			return
+		}
+		if conf.timeDilation <= 0 {
+			err = fmt.Errorf("time_dilation should be positive, got %v", conf.timeDilation)
+			return
+		}
+	}
+
+	var seed string
+	seed, err = common.GetEtcdValue(client, "/squirrel/master/seed")
+	if err != nil {
+		if common.IsEtcdNotFoundError(err) {
+			err = nil
+		} else {
+			return
+		}
+	} else {
+		conf.seed = new(int64)
+		*conf.seed, err = strconv.ParseInt(seed, 10, 64)
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+func getChannelConfigs(client *etcd.Client) (channels []channelConfig, err error) {
+	var dir *etcd.Node
+	dir, err = common.GetEtcdDir(client, "/squirrel/master/channels")
+	if err != nil || dir == nil {
+		return
+	}
+	for _, node := range dir.Nodes {
+		if !node.Dir {
+			continue
+		}
+		c := channelConfig{name: path.Base(node.Key)}
+		c.september, err = common.GetEtcdValue(client, node.Key+"/september")
+		if err != nil {
+			return
+		}
+		var septemberConfigPath string
+		septemberConfigPath, err = common.GetEtcdValue(client, node.Key+"/september_config_path")
+		if err != nil {
+			if common.IsEtcdNotFoundError(err) {
+				err = nil
+			} else {
+				return
+			}
+		} else {
+			c.septemberConfig, err = common.GetEtcdDir(client, septemberConfigPath)
+			if err != nil {
+				return
+			}
+			if c.septemberConfig == nil {
+				err = fmt.Errorf("September configuration of channel %s is not found at %s", c.name, septemberConfigPath)
+				return
+			}
+		}
+		channels = append(channels, c)
+	}
+	return
+}
+
+// getInterceptorConfigs returns configurations of the interceptor chain, in
+// order of their keys.
+func getInterceptorConfigs(client *etcd.Client) (interceptors []interceptorConfig, err error) {
+	var dir *etcd.Node
+	dir, err = common.GetEtcdDir(client, "/squirrel/master/interceptors")
+	if err != nil || dir == nil {
+		return
+	}
+	nodes := make([]*etcd.Node, 0, len(dir.Nodes))
+	for _, node := range dir.Nodes {
+		if node.Dir {
+			nodes = append(nodes, node)
+		}
+	}
+	sort.Slice(nodes, func(i, j int) bool { return path.Base(nodes[i].Key) < path.Base(nodes[j].Key) })
+	for _, node := range nodes {
+		var c interceptorConfig
+		c.interceptor, err = common.GetEtcdValue(client, node.Key+"/interceptor")
+		if err != nil {
+			return
+		}
+		var interceptorConfigPath string
+		interceptorConfigPath, err = common.GetEtcdValue(client, node.Key+"/interceptor_config_path")
+		if err != nil {
+			if common.IsEtcdNotFoundError(err) {
+				err = nil
+			} else {
+				return
+			}
+		} else {
+			c.interceptorConfig, err = common.GetEtcdDir(client, interceptorConfigPath)
+			if err != nil {
+				return
+			}
+			if c.interceptorConfig == nil {
+				err = fmt.Errorf("configuration of interceptor %s is not found at %s", path.Base(node.Key), interceptorConfigPath)
+				return
+			}
+		}
+		interceptors = append(interceptors, c)
+	}
 	return
 }
 
@@ -157,12 +403,21 @@ func runMaster(conf config) (err error) {
 	if err != nil {
 		return
 	}
-	var september squirrel.September
+	var september squirrel.SeptemberV2
 	september, err = newSeptember(conf.september)
 	if err != nil {
 		return
 	}
 
+	if conf.seed != nil {
+		if seeder, ok := mobilityManager.(squirrel.Seeder); ok {
+			seeder.Seed(*conf.seed)
+		}
+		if seeder, ok := squirrel.Implementation(september).(squirrel.Seeder); ok {
+			seeder.Seed(*conf.seed)
+		}
+	}
+
 	err = mobilityManager.Configure(conf.mobilityManagerConfig)
 	if err != nil {
 		log.Println("Creating MobilityManager failed. Following message might help:\n")
@@ -176,8 +431,136 @@ func runMaster(conf config) (err error) {
 		return
 	}
 
-	master := NewMaster(network, mobilityManager, september)
-	return master.Run(conf.uri)
+	master := NewMaster(network, conf.timeDilation, mobilityManager, september)
+	watchSeptember(conf.etcdClient, defaultChannel, september, conf.septemberConfig)
+	if conf.seed != nil {
+		master.Seed(*conf.seed)
+	}
+	for _, c := range conf.channels {
+		var september squirrel.SeptemberV2
+		september, err = newSeptember(c.september)
+		if err != nil {
+			return
+		}
+		if seeder, ok := squirrel.Implementation(september).(squirrel.Seeder); ok && conf.seed != nil {
+			seeder.Seed(*conf.seed)
+		}
+		err = september.Configure(c.septemberConfig)
+		if err != nil {
+			log.Printf("Creating September for channel %s failed. Following message might help:\n\n", c.name)
+			log.Println(september.ParametersHelp())
+			return
+		}
+		err = master.AddChannel(c.name, september)
+		if err != nil {
+			return
+		}
+		watchSeptember(conf.etcdClient, c.name, september, c.septemberConfig)
+	}
+	for _, c := range conf.interceptors {
+		var interceptor squirrel.FrameInterceptor
+		interceptor, err = newFrameInterceptor(c.interceptor)
+		if err != nil {
+			return
+		}
+		err = interceptor.Configure(c.interceptorConfig)
+		if err != nil {
+			log.Printf("Creating interceptor %s failed. Following message might help:\n\n", c.interceptor)
+			log.Println(interceptor.ParametersHelp())
+			return
+		}
+		master.AddInterceptor(interceptor)
+	}
+	if *record != "" && *replay != "" {
+		return errors.New("-record and -replay can't be used together")
+	}
+	if *record != "" {
+		var f *os.File
+		if f, err = os.Create(*record); err != nil {
+			return
+		}
+		master.RecordDecisions(f)
+	}
+	// capture files and recorded decisions are flushed on exit
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		if err := master.Close(); err != nil {
+			log.Printf("closing master error: %v\n", err)
+		}
+		log.Printf("exiting on %v\n", sig)
+		os.Exit(1)
+	}()
+	if *replay != "" {
+		var f *os.File
+		if f, err = os.Open(*replay); err != nil {
+			return
+		}
+		err = master.ReplayDecisions(f)
+		f.Close()
+		if err != nil {
+			return
+		}
+	}
+	master.Impairments, err = parseImpairments(conf.impairments)
+	if err != nil {
+		return
+	}
+	master.MulticastSnooping = conf.multicastSnooping
+	master.UnknownUnicast, err = parseUnknownUnicastPolicy(conf.unknownUnicast)
+	if err != nil {
+		return
+	}
+	if master.UnknownUnicast == unknownUnicastDeliver {
+		if _, err = master.addressPool.GetAddress(conf.unknownUnicastNode); err != nil {
+			return
+		}
+	}
+	master.UnknownUnicastNode = conf.unknownUnicastNode
+	master.DropWhenQueueFull = conf.dropWhenQueueFull
+	if conf.serializeMedium {
+		master.SerializeMedium(conf.mediumWindow)
+	}
+	go func() {
+		err := common.WatchEtcdDir(conf.etcdClient, "/squirrel/master/capture", master.capture.Configure)
+		log.Printf("watching capture configuration error: %v\n", err)
+	}()
+	go func() {
+		err := common.WatchEtcdDir(conf.etcdClient, "/squirrel/master/channel_assignment", master.channels.Assign)
+		log.Printf("watching channel assignment error: %v\n", err)
+	}()
+	go func() {
+		err := common.WatchEtcdDir(conf.etcdClient, "/squirrel/master/acl", master.acl.Configure)
+		log.Printf("watching ACL error: %v\n", err)
+	}()
+	if *httpAddr != "" {
+		go func() {
+			log.Fatalf("serving HTTP on %s error: %v\n", *httpAddr, master.ListenAndServeHTTP(*httpAddr))
+		}()
+	}
+	err = master.Run(conf.uri)
+	if cerr := master.Close(); err == nil {
+		err = cerr
+	}
+	return
+}
+
+// watchSeptember reconfigures september of channel whenever its configuration
+// node conf changes, if it implements squirrel.Reconfigurer.
+func watchSeptember(client *etcd.Client, channel string, september squirrel.SeptemberV2, conf *etcd.Node) {
+	reconfigurer, ok := squirrel.Implementation(september).(squirrel.Reconfigurer)
+	if !ok || conf == nil {
+		return
+	}
+	go func() {
+		err := common.WatchEtcdDir(client, conf.Key, func(dir *etcd.Node) {
+			if err := reconfigurer.Reconfigure(dir); err != nil {
+				log.Printf("invalid configuration of September of channel %s; keeping the current one: %v\n", channel, err)
+			}
+		})
+		log.Printf("watching configuration of September of channel %s error: %v\n", channel, err)
+	}()
 }
 
 func printHelp() {
@@ -195,13 +578,95 @@ func printHelp() {
 	fmt.Println("    /squirrel/master/mobility_manager_config_path [Optional]")
 	fmt.Println("        Configuration node (a Dir) of the Mobility Manager.")
 	fmt.Println("    /squirrel/master/september                    [Required]")
-	fmt.Println("        Name of the September.")
+	fmt.Println("        Name of the September. Built-in ones, besides those in models:")
+	fmt.Println("          composite       : chains several Septembers; see its parameters help.")
+	fmt.Println("          rx_power        : received power vs. sensitivity, with a path loss model.")
+	fmt.Println("          trace           : replays per-link measurements from a CSV or JSON file.")
+	fmt.Println("          gilbert_elliott : bursty loss with a two-state Markov chain per link.")
+	fmt.Println("          link_matrix     : explicit matrix of links and their loss; reconfigurable.")
 	fmt.Println("    /squirrel/master/september_config_path        [Optional]")
-	fmt.Println("        Configuration node (a Dir) of the September.")
+	fmt.Println("        Configuration node (a Dir) of the September. Watched for changes if")
+	fmt.Println("        the September can be reconfigured at runtime, as can those of")
+	fmt.Println("        channels below.")
+	fmt.Println("    /squirrel/master/channels                     [Optional]")
+	fmt.Println("        Additional radio channels (a Dir), each a Dir named after the")
+	fmt.Println("        channel, with a September of its own that only sees nodes on it:")
+	fmt.Println("          september             : name of the September.")
+	fmt.Println("          september_config_path : configuration node (a Dir) of it.")
+	fmt.Println("        The September above is on channel \"default\".")
+	fmt.Println("    /squirrel/master/channel_assignment           [Optional]")
+	fmt.Println("        Channels (a Dir) of each node, keyed by identity, as comma")
+	fmt.Println("        separated channel names. Frames are sent on all channels of their")
+	fmt.Println("        sender, and only nodes on those channels can receive them. Nodes")
+	fmt.Println("        not listed are on channel \"default\". Watched for changes.")
+	fmt.Println("    /squirrel/master/interceptors                 [Optional]")
+	fmt.Println("        Chain of frame interceptors (a Dir) that act upon frames before")
+	fmt.Println("        and after the September decides upon them. Each is a Dir, in order")
+	fmt.Println("        of their keys, with:")
+	fmt.Println("          interceptor             : name the interceptor is registered as,")
+	fmt.Println("                                    with squirrel.RegisterFrameInterceptor.")
+	fmt.Println("          interceptor_config_path : configuration node (a Dir) of it.")
+	fmt.Println("    /squirrel/master/impairments                  [Optional]")
+	fmt.Println("        Link impairments (a Dir) applied to frames September decided to")
+	fmt.Println("        deliver. Keys can be set globally, or per directed link under")
+	fmt.Println("        links/<source identity>-<destination identity>/ :")
+	fmt.Println("          bit_error_rate : probability of each bit being flipped.")
+	fmt.Println("                           Ignored if the September provides its own.")
+	fmt.Println("          duplicate_probability : probability of a frame being delivered twice.")
+	fmt.Println("          reorder_probability   : probability of a frame being held back so")
+	fmt.Println("                                  that later frames overtake it.")
+	fmt.Println("          reorder_delay_ms      : maximum time a frame is held back for.")
+	fmt.Println("    /squirrel/master/multicast_snooping           [Optional]")
+	fmt.Println("        Whether to deliver multicast frames only to group members learned")
+	fmt.Println("        from IGMP/MLD. If false, multicast is flooded like broadcast.")
+	fmt.Println("        Default: true")
+	fmt.Println("    /squirrel/master/unknown_unicast              [Optional]")
+	fmt.Println("        What to do with unicast frames to addresses that don't belong to")
+	fmt.Println("        any node: drop, flood (deliver like broadcast), or deliver (to the")
+	fmt.Println("        node in unknown_unicast_node, subject to the September).")
+	fmt.Println("        Default: drop")
+	fmt.Println("    /squirrel/master/unknown_unicast_node         [Required if deliver]")
+	fmt.Println("        Identity of the node unknown unicast frames are delivered to.")
+	fmt.Println("    /squirrel/master/drop_when_queue_full         [Optional]")
+	fmt.Println("        Whether to drop frames to a node whose outgoing queue is full,")
+	fmt.Println("        rather than waiting for room in it. Default: false")
+	fmt.Println("    /squirrel/master/serialize_medium             [Optional]")
+	fmt.Println("        Whether to handle frames from all nodes in a single routine, in")
+	fmt.Println("        order of arrival, so that September decisions are reproducible.")
+	fmt.Println("        Default: false")
+	fmt.Println("    /squirrel/master/medium_window_us             [Optional]")
+	fmt.Println("        With serialize_medium, how long each frame is held back so that")
+	fmt.Println("        frames that arrived earlier but are late to be queued can go")
+	fmt.Println("        ahead of it. Default: 0")
+	fmt.Println("    /squirrel/master/seed                         [Optional]")
+	fmt.Println("        Seed for all randomness in master, and in the Mobility Manager and")
+	fmt.Println("        the September if they support seeding. Default: random")
+	fmt.Println("    /squirrel/master/time_dilation                [Optional]")
+	fmt.Println("        Factor by which emulated time runs slower than wall clock, for")
+	fmt.Println("        emulating more nodes than the host can handle in real time.")
+	fmt.Println("        Default: 1")
+	fmt.Println("    /squirrel/master/acl                          [Optional]")
+	fmt.Println("        Rules (a Dir, ordered by key) that deny or allow frames between")
+	fmt.Println("        nodes regardless of the September, e.g. \"deny src=5 dst=7\",")
+	fmt.Println("        \"deny ethertype=ipv6\" or \"deny ip_proto=udp dst_port=53\". The first")
+	fmt.Println("        matching rule applies; frames matching none are allowed. Fields:")
+	fmt.Println("        src, dst, src_mac, dst_mac, ethertype, ip_proto, src_port,")
+	fmt.Println("        dst_port, port. Watched for changes.")
+	fmt.Println("    /squirrel/master/capture                      [Optional]")
+	fmt.Println("        Capture of frames into pcapng files (a Dir), each frame commented")
+	fmt.Println("        with its sender, destination and recipients. Watched for changes.")
+	fmt.Println("          enabled        : true or false. Default: false")
+	fmt.Println("          dir            : directory to write files into. Default: .")
+	fmt.Println("          global         : write a file with all frames. Default: true")
+	fmt.Println("          per_identity   : write a file for each identity. Default: false")
+	fmt.Println("          rotate_size_mb : start a new file after this size. Default: never")
 }
 
 var cpuprofile = flag.String("cpuprofile", "", "write cpu profile to file; if specified, squirrel-master runs for 60 seconds and exits.")
 var debug = flag.Bool("debug", false, "verbose logging for debug purposes")
+var record = flag.String("record", "", "record every decision of the September into file")
+var replay = flag.String("replay", "", "apply decisions recorded with -record from file instead of asking the September")
+var httpAddr = flag.String("http", "", "address to serve status endpoints on, e.g. :8080 (/stats for counters, /acl for ACL hits, /septembers/<channel> for state of Septembers that serve it, and /septembers/<channel>/<stage> for stages of composite ones, /pcap?identity=X&decision=delivered|dropped for a live pcapng stream); disabled if empty")
 
 func main() {
 	log.SetOutput(os.Stdout)
@@ -1,51 +1,203 @@
 package main
 
 import (
-	"errors"
+	"fmt"
+	"io"
 	"log"
+	"math/rand"
 	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/songgao/packets/ethernet"
 	"github.com/squirrel-land/squirrel"
 	"github.com/squirrel-land/squirrel/common"
 )
 
-type client struct {
-	Link *common.Link
-	Addr net.HardwareAddr
+type unknownUnicastPolicy int
+
+const (
+	// drop the frame
+	unknownUnicastDrop unknownUnicastPolicy = iota
+	// deliver the frame as if it was a broadcast one
+	unknownUnicastFlood
+	// deliver the frame to Master.UnknownUnicastNode, as if it was addressed to
+	// that node
+	unknownUnicastDeliver
+)
+
+func parseUnknownUnicastPolicy(s string) (policy unknownUnicastPolicy, err error) {
+	switch s {
+	case "drop":
+		policy = unknownUnicastDrop
+	case "flood":
+		policy = unknownUnicastFlood
+	case "deliver":
+		policy = unknownUnicastDeliver
+	default:
+		err = fmt.Errorf("unknown policy for unknown unicast: %q (expected drop, flood, or deliver)", s)
+	}
+	return
+}
+
+// decider makes decisions upon frames, as September does.
+type decider interface {
+	SendUnicast(source int, destination int, frame *squirrel.Frame) squirrel.Result
+	SendBroadcast(source int, frame *squirrel.Frame, underlying []squirrel.Result) []squirrel.Result
+}
+
+// scratch holds slices a routine handling frames reuses for every frame, to
+// reduce workload of GC.
+type scratch struct {
+	recipients []int
+	results    []squirrel.Result
 }
 
 type Master struct {
 	addressPool     *addressPool
-	clients         []*client
+	clients         *clientRegistry
 	addrReverse     *addressReverse
 	positionManager squirrel.PositionManager
 
+	clock           *dilatedClock
 	mobilityManager squirrel.MobilityManager
-	september       squirrel.September
+	channels        *channels
+
+	// decider makes decisions upon frames. It's channels, unless decisions are
+	// recorded or replayed.
+	decider decider
+	// recorder records decisions if RecordDecisions was called.
+	recorder *recordingSeptember
+
+	// seed, if seeded, is used to derive all randomness in master.
+	seed   int64
+	seeded bool
+
+	// Impairments is used for links that September doesn't provide
+	// parameters for. It should be set before Run is called.
+	Impairments *impairments
+
+	// MulticastSnooping makes master deliver multicast frames only to members
+	// of the group, learned by snooping IGMP/MLD messages. Otherwise multicast
+	// frames are delivered like broadcast ones. It should be set before Run is
+	// called.
+	MulticastSnooping bool
+	groups            *multicastGroups
+
+	// UnknownUnicast decides what happens to unicast frames whose destination
+	// address doesn't belong to any client. UnknownUnicastNode is the identity
+	// frames are delivered to under unknownUnicastDeliver. They should be set
+	// before Run is called.
+	UnknownUnicast     unknownUnicastPolicy
+	UnknownUnicastNode int
+
+	// DropWhenQueueFull makes master drop frames to a client whose outgoing
+	// queue is full, rather than waiting for room in the queue. It should be
+	// set before Run is called.
+	DropWhenQueueFull bool
+
+	// medium, if not nil, serializes frames from all clients, so that they
+	// are handled in a single routine in order of arrival.
+	medium *medium
+
+	// interceptors act upon frames before and after decisions, in order.
+	interceptors []squirrel.FrameInterceptor
+
+	acl      *acl
+	monitors *monitors
+	capture  *capture
+	streams  *streams
+	wirePool *common.SlicePool
+	stats    *stats
+
+	// deferredScratches and deferredRands are used for frames handled out of
+	// the routine of their source, i.e. delayed by interceptors or injected.
+	deferredScratches sync.Pool // *scratch
+	deferredRands     map[int]*rand.Rand
+	deferredRandsMu   sync.Mutex
 }
 
-func NewMaster(network *net.IPNet, mobilityManager squirrel.MobilityManager, september squirrel.September) (master *Master) {
-	master = &Master{addressPool: newAddressPool(network), addrReverse: newAddressReverse(), mobilityManager: mobilityManager, september: september}
-	master.clients = make([]*client, master.addressPool.Capacity()+1, master.addressPool.Capacity()+1)
+// NewMaster creates a Master. timeDilation is the factor by which emulated time
+// runs slower than the wall clock; it's 1 for real time.
+func NewMaster(network *net.IPNet, timeDilation float64, mobilityManager squirrel.MobilityManager, september squirrel.SeptemberV2) (master *Master) {
+	master = &Master{addressPool: newAddressPool(network), addrReverse: newAddressReverse(), mobilityManager: mobilityManager}
+	master.clock = newDilatedClock(timeDilation)
+	master.Impairments = newImpairments()
+	master.MulticastSnooping = true
+	master.groups = newMulticastGroups()
+	master.acl = newACL()
+	master.capture = newCapture(master.clock)
+	master.streams = newStreams(master.clock)
+	master.wirePool = common.NewSlicePool(1600)
+	master.deferredScratches.New = func() interface{} { return master.newScratch() }
+	master.deferredRands = make(map[int]*rand.Rand)
+	master.stats = newStats()
+	master.monitors = newMonitors(master.stats)
+	master.clients = newClientRegistry(master.addressPool.Capacity() + 1)
 	master.positionManager = NewPositionManager(master.addressPool.Capacity()+1, master.addrReverse)
+	if user, ok := master.mobilityManager.(squirrel.ClockUser); ok {
+		user.SetClock(master.clock)
+	}
 	master.mobilityManager.Initialize(master.positionManager)
-	master.september.Initialize(master.positionManager)
+	master.channels = newChannels(master.positionManager, master.addrReverse)
+	master.decider = master.channels
+	master.AddChannel(defaultChannel, september)
 	return
 }
 
-func (master *Master) clientJoin(identity int, addr net.HardwareAddr, link *common.Link) {
-	master.clients[identity] = &client{Link: link, Addr: addr}
-	master.positionManager.Enable(identity)
+// AddChannel adds a channel named name, with september making decisions upon
+// frames sent on it. september should be configured, but not initialized.
+// Identities are assigned to channels with channels.Assign; those that are not
+// are on the channel of the September master is created with. It should be
+// called before Run.
+func (master *Master) AddChannel(name string, september squirrel.SeptemberV2) (err error) {
+	var ch *channel
+	if ch, err = master.channels.add(name, september); err != nil {
+		return
+	}
+	if user, ok := squirrel.Implementation(september).(squirrel.ClockUser); ok {
+		user.SetClock(master.clock)
+	}
+	if user, ok := squirrel.Implementation(september).(squirrel.NodeDirectoryUser); ok {
+		user.SetNodeDirectory(master)
+	}
+	september.Initialize(ch.view)
+	return
+}
+
+// Identity implements squirrel.NodeDirectory.
+func (master *Master) Identity(node string) (identity int, ok bool) {
+	if identity, ok = master.addrReverse.GetName(node); ok {
+		return
+	}
+	return master.addrReverse.GetS(node)
+}
+
+func (master *Master) clientJoin(identity int, addr net.HardwareAddr, name string) {
+	// the directory is updated first, for Septembers that resolve nodes when
+	// they're enabled
 	master.addrReverse.Add(addr, identity)
+	if name != "" {
+		master.addrReverse.AddName(name, identity)
+	}
+	master.positionManager.Enable(identity)
 	ipAddr, _ := master.addressPool.GetAddress(identity)
 	log.Printf("%v joined\n", ipAddr)
 }
 
 func (master *Master) clientLeave(identity int, err error) {
-	master.addrReverse.Remove(master.clients[identity].Addr)
-	master.clients[identity] = nil
+	// identity is freed last, so that a client joining meanwhile can't be
+	// given identity and then have its state cleaned up here
+	c := master.clients.Get(identity)
+	master.addrReverse.Remove(c.Addr, identity)
+	if c.Name != "" {
+		master.addrReverse.RemoveName(c.Name, identity)
+	}
+	master.groups.leaveAll(identity)
 	master.positionManager.Disable(identity)
+	master.clients.Remove(identity)
 	addr, _ := master.addressPool.GetAddress(identity)
 	if err == nil {
 		log.Printf("link to %v is terminated with no error\n", addr)
@@ -55,7 +207,7 @@ func (master *Master) clientLeave(identity int, err error) {
 	log.Printf("%v left\n", addr)
 }
 
-func (master *Master) accept(listener net.Listener) (identity int, err error) {
+func (master *Master) accept(listener net.Listener) (err error) {
 	var connection net.Conn
 	connection, err = listener.Accept()
 	if err != nil {
@@ -69,105 +221,608 @@ func (master *Master) accept(listener net.Listener) (identity int, err error) {
 		return
 	}
 
-	for identity = 1; identity < len(master.clients); identity++ {
-		if master.clients[identity] == nil {
-			break
+	if req.Monitor {
+		err = link.SendJoinRsp(&common.JoinRsp{Error: nil})
+		if err != nil {
+			return
 		}
+		master.monitors.add(link)
+		log.Printf("monitor %v joined\n", req.MACAddr)
+		link.StartRoutines()
+		go master.monitorHandler(req.MACAddr, link)
+		return
 	}
-	if identity == len(master.clients) {
-		err = errors.New("Adress poll is full")
+
+	var identity int
+	identity, err = master.clients.Add(&client{Link: link, Addr: req.MACAddr, Name: req.Name})
+	if err != nil {
 		link.SendJoinRsp(&common.JoinRsp{Error: err})
 		return
 	}
 
 	var addr net.IP
 	addr, err = master.addressPool.GetAddress(identity)
-	if err != nil {
-		return
+	if err == nil {
+		err = link.SendJoinRsp(&common.JoinRsp{Address: addr, Mask: master.addressPool.Network.Mask, Error: nil})
 	}
-	err = link.SendJoinRsp(&common.JoinRsp{Address: addr, Mask: master.addressPool.Network.Mask, Error: nil})
 	if err != nil {
+		master.clients.Remove(identity)
 		return
 	}
-	master.clientJoin(identity, req.MACAddr, link)
+	master.clientJoin(identity, req.MACAddr, req.Name)
 	link.StartRoutines()
-	return identity, nil
+	go master.frameHandler(identity, link)
+	return
+}
+
+// monitorHandler discards whatever a monitor sends, until its link is
+// terminated.
+func (master *Master) monitorHandler(addr net.HardwareAddr, link *common.Link) {
+	for {
+		buf, ok := link.ReadFrame()
+		if !ok {
+			break
+		}
+		buf.Done()
+	}
+	master.monitors.remove(link)
+	if err := link.IncomingError(); err == nil {
+		log.Printf("link to monitor %v is terminated with no error\n", addr)
+	} else {
+		log.Printf("link to monitor %v is terminated with error: %v\n", addr, err)
+	}
+	log.Printf("monitor %v left\n", addr)
 }
 
-func isBroadcast(addr net.HardwareAddr) bool {
-	return addr[0] == 0xff && addr[1] == 0xff && addr[2] == 0xff && addr[3] == 0xff && addr[4] == 0xff && addr[5] == 0xff
+// isGroup returns whether addr is a group (broadcast or multicast) address,
+// i.e. whether its I/G bit is set.
+func isGroup(addr net.HardwareAddr) bool {
+	return addr[0]&0x01 == 0x01
 }
 
 func isIPv4Multicast(addr net.HardwareAddr) bool {
 	return addr[0] == 0x01 && addr[1] == 0x00 && addr[2] == 0x5e
 }
 
-func (master *Master) frameHandler(myIdentity int) {
-	var (
-		buf        *common.ReusableSlice
-		ok         bool
-		underlying = make([]int, master.addressPool.Capacity()+1)
-	)
+func isIPv6Multicast(addr net.HardwareAddr) bool {
+	return addr[0] == 0x33 && addr[1] == 0x33
+}
 
-	for {
-		buf, ok = master.clients[myIdentity].Link.ReadFrame()
-		if !ok {
-			break
+// observe is called for every frame that goes through master, with identities
+// of its source, its destination (0 if frame is addressed to a group or an
+// unknown address), and recipients it is to be delivered to.
+func (master *Master) observe(frame []byte, source int, destination int, recipients []int) {
+	master.monitors.send(frame, source, destination, recipients)
+	master.capture.record(frame, source, destination, recipients)
+	master.streams.record(frame, source, destination, recipients)
+}
+
+func (master *Master) bitErrorRate(source, destination int) float64 {
+	if ber, ok := master.channels.bitErrorRate(source, destination); ok {
+		return ber
+	}
+	return master.Impairments.get(source, destination).BitErrorRate
+}
+
+// encode returns frame encoded by common.AppendEncodedFrame, so that it can be
+// written to any number of links without being encoded again for each of
+// them.
+func (master *Master) encode(frame []byte) *common.ReusableSlice {
+	encoded := master.wirePool.Get()
+	*encoded.SlicePtr() = common.AppendEncodedFrame(encoded.Slice()[:0], frame)
+	return encoded
+}
+
+// deliver writes frame, encoded in encoded, from source to destination,
+// applying impairments of the link: the frame may be corrupted, duplicated, or
+// held back so that later frames overtake it. If report is not nil, it's called
+// with the fate of the frame. It takes over the ownership of encoded.
+func (master *Master) deliver(source, destination int, frame ethernet.Frame, encoded *common.ReusableSlice, rng *rand.Rand, report func(squirrel.Fate)) {
+	c := master.clients.Get(destination)
+	if c == nil {
+		// destination left after September's decision
+		encoded.Done()
+		if report != nil {
+			report(squirrel.FateDestinationLeft)
 		}
-		frame := ethernet.Frame(buf.Slice())
-		dst := frame.Destination()
-		if isBroadcast(dst) || isIPv4Multicast(dst) {
-			recipients := master.september.SendBroadcast(myIdentity, len(frame.Payload()), underlying)
-			for _, id := range recipients {
-				if master.clients[id] != nil {
-					buf.AddOwner()
-					master.clients[id].Link.WriteFrame(buf)
-					if *debug {
-						log.Printf("broadcast frame of length %d from client %d to be delivered to client %d\n", len(frame.Payload()), myIdentity, id)
-					}
-				}
-			}
-			buf.Done()
-		} else { // unicast
-			dstID, ok := master.addrReverse.Get(dst)
-			if ok {
-				if master.september.SendUnicast(myIdentity, dstID, len(frame.Payload())) {
-					master.clients[dstID].Link.WriteFrame(buf)
-					if *debug {
-						log.Printf("unicast frame of length %d from client %d to be delivered to client %d\n", len(frame.Payload()), myIdentity, dstID)
-					}
+		return
+	}
+	link := c.Link
+	counters := master.stats.link(source, destination)
+	imp := master.Impairments.get(source, destination)
+	if ber := master.bitErrorRate(source, destination); ber > 0 {
+		payloadLen := len(frame.Payload())
+		if first := nextBitError(ber, rng); first < payloadLen*8 {
+			// encoded may be shared with other recipients, so corrupt a copy.
+			// Encoding ends with the frame, so payload is at the very end.
+			corrupted := master.wirePool.Get()
+			*corrupted.SlicePtr() = append(corrupted.Slice()[:0], encoded.Slice()...)
+			encoded.Done()
+			encoded = corrupted
+			corrupt(encoded.Slice()[len(encoded.Slice())-payloadLen:], first, ber, rng)
+			atomic.AddUint64(&counters.Corrupted, 1)
+		}
+	}
+	copies := 1
+	if imp.DuplicateProbability > 0 && rng.Float64() < imp.DuplicateProbability {
+		encoded.AddOwner()
+		copies = 2
+		atomic.AddUint64(&counters.Duplicated, 1)
+	}
+	for i := 0; i < copies; i++ {
+		if i > 0 {
+			// fate is only reported for the original
+			report = nil
+		}
+		if delay := imp.holdBack(rng); delay > 0 {
+			atomic.AddUint64(&counters.Reordered, 1)
+			report := report
+			time.AfterFunc(master.clock.wall(delay), func() {
+				// destination might have left, or even been replaced by another
+				// client, while the frame was held back.
+				if c := master.clients.Get(destination); c != nil && c.Link == link {
+					master.write(link, counters, encoded, report)
 				} else {
-					buf.Done()
-					if *debug {
-						log.Printf("unicast frame of length %d from client %d NOT to be delivered to client %d\n", len(frame.Payload()), myIdentity, dstID)
+					encoded.Done()
+					if report != nil {
+						report(squirrel.FateDestinationLeft)
 					}
 				}
+			})
+		} else {
+			master.write(link, counters, encoded, report)
+		}
+	}
+}
+
+// write writes encoded to link, whose counters are counters. If report is not
+// nil, it's called with the fate of the frame. It takes over the ownership of
+// encoded.
+func (master *Master) write(link *common.Link, counters *linkCounters, encoded *common.ReusableSlice, report func(squirrel.Fate)) {
+	var written func(err error)
+	if report != nil {
+		written = func(err error) {
+			if err == nil {
+				report(squirrel.FateWritten)
+			} else {
+				report(squirrel.FateDestinationLeft)
+			}
+		}
+	}
+	if master.DropWhenQueueFull {
+		if !link.TryWriteEncodedFrame(encoded, written) {
+			encoded.Done()
+			atomic.AddUint64(&counters.Dropped[squirrel.DroppedQueueFull], 1)
+			if report != nil {
+				report(squirrel.FateQueueFull)
+			}
+			return
+		}
+	} else {
+		link.WriteEncodedFrameNotify(encoded, written)
+	}
+	atomic.AddUint64(&counters.Delivered, 1)
+}
+
+// reporter returns a function that tells Septembers the fate of frame with
+// meta from source to destination.
+func (master *Master) reporter(source, destination int, meta squirrel.FrameMeta) func(squirrel.Fate) {
+	return func(fate squirrel.Fate) {
+		master.channels.feedback(&squirrel.Feedback{
+			Source:      source,
+			Destination: destination,
+			Meta:        meta,
+			Fate:        fate,
+			Time:        master.clock.Now(),
+		})
+	}
+}
+
+// sendBroadcast delivers frame in buf from source to all recipients September
+// chooses, and if it's addressed to a snooped multicast group, only to those
+// that are members of the group. It takes over the ownership of buf.
+func (master *Master) sendBroadcast(source int, buf *common.ReusableSlice, view *squirrel.Frame, s *scratch, rng *rand.Rand) {
+	dst := ethernet.Frame(buf.Slice()).Destination()
+	recipients := s.recipients[:0]
+	for _, r := range master.decider.SendBroadcast(source, view, s.results) {
+		master.account(source, &r)
+		if r.Delivered {
+			recipients = append(recipients, r.Destination)
+		}
+	}
+	var approved []int
+	if master.channels.wantsFeedback {
+		approved = append([]int(nil), recipients...)
+	}
+	if master.MulticastSnooping && isSnoopedGroup(dst) {
+		recipients = master.groups.filter(dst, recipients)
+	}
+	if !master.acl.empty() {
+		recipients = master.acl.filter(source, view, recipients)
+	}
+	master.forward(source, 0, buf, view, recipients, approved, rng)
+}
+
+// sendUnicast delivers frame in buf from source to destination if September
+// approves. It takes over the ownership of buf.
+func (master *Master) sendUnicast(source, destination int, buf *common.ReusableSlice, view *squirrel.Frame, rng *rand.Rand) {
+	var recipients, approved []int
+	r := master.decider.SendUnicast(source, destination, view)
+	master.account(source, &r)
+	if r.Delivered {
+		recipients = []int{destination}
+		if master.channels.wantsFeedback {
+			approved = append([]int(nil), recipients...)
+		}
+	}
+	// as for broadcast, the ACL applies on top of the decision
+	if len(recipients) > 0 && !master.acl.empty() && !master.acl.allow(source, destination, view) {
+		if *debug {
+			log.Printf("unicast frame of length %d from client %d to client %d is denied by ACL\n", view.Meta.PayloadSize, source, destination)
+		}
+		recipients = nil
+	}
+	master.forward(source, destination, buf, view, recipients, approved, rng)
+}
+
+// forward delivers frame in buf, viewed by view, from source to recipients,
+// after letting interceptors act upon the decision. destination is the
+// identity frame is addressed to, or 0 if it's addressed to a group or an
+// unknown address. If approved is not nil, fates of the frame are reported to
+// Septembers for each of approved, the recipients they decided upon. It takes
+// over the ownership of buf.
+func (master *Master) forward(source, destination int, buf *common.ReusableSlice, view *squirrel.Frame, recipients []int, approved []int, rng *rand.Rand) {
+	var delay time.Duration
+	if len(master.interceptors) > 0 {
+		f := &squirrel.InterceptedFrame{Source: source, Destination: destination, Data: buf.Slice()}
+		for _, interceptor := range master.interceptors {
+			recipients = interceptor.AfterDecision(f, recipients)
+		}
+		setData(buf, f.Data)
+		delay = f.Delay
+	}
+	var reports map[int]func(squirrel.Fate)
+	if len(approved) > 0 {
+		delivered := make(map[int]bool, len(recipients))
+		for _, id := range recipients {
+			delivered[id] = true
+		}
+		reports = make(map[int]func(squirrel.Fate), len(approved))
+		for _, id := range approved {
+			report := master.reporter(source, id, view.Meta)
+			if delivered[id] {
+				reports[id] = report
 			} else {
+				report(squirrel.FateFiltered)
+			}
+		}
+	}
+	master.observe(buf.Slice(), source, destination, recipients)
+	if len(recipients) > 0 && delay > 0 {
+		// recipients may be in scratch, which is reused for the next frame.
+		recipients = append([]int(nil), recipients...)
+		time.AfterFunc(master.clock.wall(delay), func() {
+			master.deliverAll(source, destination, buf, recipients, reports, master.deferredRand(source))
+		})
+		return
+	}
+	master.deliverAll(source, destination, buf, recipients, reports, rng)
+}
+
+// deliverAll delivers frame in buf from source to each of recipients. Fate of
+// the frame for each recipient is reported to reports[recipient], if any. It
+// takes over the ownership of buf.
+func (master *Master) deliverAll(source, destination int, buf *common.ReusableSlice, recipients []int, reports map[int]func(squirrel.Fate), rng *rand.Rand) {
+	frame := ethernet.Frame(buf.Slice())
+	if len(recipients) == 0 {
+		if *debug && destination != 0 {
+			log.Printf("unicast frame of length %d from client %d NOT to be delivered to client %d\n", len(frame.Payload()), source, destination)
+		}
+		buf.Done()
+		return
+	}
+	encoded := master.encode(frame)
+	for _, id := range recipients {
+		encoded.AddOwner()
+		master.deliver(source, id, frame, encoded, rng, reports[id])
+		if *debug {
+			log.Printf("frame of length %d from client %d to be delivered to client %d\n", len(frame.Payload()), source, id)
+		}
+	}
+	encoded.Done()
+	buf.Done()
+}
+
+// sendUnknownUnicast handles a unicast frame in buf whose destination address
+// doesn't belong to any client, according to master.UnknownUnicast. It takes
+// over the ownership of buf.
+func (master *Master) sendUnknownUnicast(source int, buf *common.ReusableSlice, view *squirrel.Frame, s *scratch, rng *rand.Rand) {
+	frame := ethernet.Frame(buf.Slice())
+	atomic.AddUint64(&master.stats.node(source).UnknownDestination, 1)
+	if *debug {
+		log.Printf("unicast frame of length %d from client %d has unknown dst address: %v\n", len(frame.Payload()), source, frame.Destination())
+	}
+	switch master.UnknownUnicast {
+	case unknownUnicastFlood:
+		master.sendBroadcast(source, buf, view, s, rng)
+	case unknownUnicastDeliver:
+		if master.clients.Get(master.UnknownUnicastNode) != nil && master.UnknownUnicastNode != source {
+			master.sendUnicast(source, master.UnknownUnicastNode, buf, view, rng)
+		} else {
+			master.observe(frame, source, 0, nil)
+			buf.Done()
+		}
+	default:
+		master.observe(frame, source, 0, nil)
+		buf.Done()
+	}
+}
+
+// handleFrame lets interceptors act upon frame in buf from source, read at
+// received, and then decides what to do with it and delivers it accordingly.
+// It takes over the ownership of buf.
+func (master *Master) handleFrame(source int, buf *common.ReusableSlice, received time.Time, s *scratch, rng *rand.Rand) {
+	if len(master.interceptors) > 0 {
+		frame := ethernet.Frame(buf.Slice())
+		f := &squirrel.InterceptedFrame{Source: source, Data: frame}
+		if dstID, ok := master.addrReverse.Get(frame.Destination()); ok && !isGroup(frame.Destination()) {
+			f.Destination = dstID
+		}
+		for _, interceptor := range master.interceptors {
+			if !interceptor.BeforeDecision(f) {
 				if *debug {
-					log.Printf("unicast frame of length %d from client %d has unknown dst address: %v\n", len(frame.Payload()), myIdentity, dst)
+					log.Printf("frame of length %d from client %d is dropped by interceptor\n", len(frame.Payload()), source)
 				}
+				master.observe(frame, source, f.Destination, nil)
+				buf.Done()
+				return
+			}
+		}
+		setData(buf, f.Data)
+		if f.Delay > 0 {
+			if master.medium != nil {
+				// decided upon in the medium routine, to keep the order
+				// of decisions reproducible
+				master.medium.hold(source, buf, received, received.Add(f.Delay))
+				return
 			}
+			time.AfterFunc(master.clock.wall(f.Delay), func() {
+				master.decideDeferred(source, buf, received)
+			})
+			return
 		}
 	}
-	master.clientLeave(myIdentity, master.clients[myIdentity].Link.IncomingError())
+	master.decide(source, buf, received, s, rng)
 }
 
-func (master *Master) Run(laddr string) (err error) {
+// decide decides what to do with frame in buf from source, read at received,
+// and delivers it accordingly. It takes over the ownership of buf.
+func (master *Master) decide(source int, buf *common.ReusableSlice, received time.Time, s *scratch, rng *rand.Rand) {
+	frame := ethernet.Frame(buf.Slice())
+	dst := frame.Destination()
+	if master.MulticastSnooping {
+		master.groups.snoop(source, frame)
+	}
+	view := &squirrel.Frame{Data: frame, Meta: squirrel.ParseFrameMeta(frame, received)}
+	if isGroup(dst) {
+		master.sendBroadcast(source, buf, view, s, rng)
+	} else if dstID, ok := master.addrReverse.Get(dst); ok {
+		master.sendUnicast(source, dstID, buf, view, rng)
+	} else {
+		master.sendUnknownUnicast(source, buf, view, s, rng)
+	}
+}
+
+// decideDeferred is like decide, but for frames handled out of the routine of
+// their source.
+func (master *Master) decideDeferred(source int, buf *common.ReusableSlice, received time.Time) {
+	s := master.deferredScratches.Get().(*scratch)
+	master.decide(source, buf, received, s, master.deferredRand(source))
+	master.deferredScratches.Put(s)
+}
+
+func (master *Master) newScratch() *scratch {
+	n := master.addressPool.Capacity() + 1
+	return &scratch{recipients: make([]int, n), results: make([]squirrel.Result, n)}
+}
+
+// account updates statistics of the link from source to the destination of r
+// with decision r.
+func (master *Master) account(source int, r *squirrel.Result) {
+	if r.Delivered && r.Retries == 0 {
+		return
+	}
+	counters := master.stats.link(source, r.Destination)
+	if r.Retries > 0 {
+		atomic.AddUint64(&counters.Retries, uint64(r.Retries))
+	}
+	if !r.Delivered {
+		reason := r.Reason
+		if reason <= squirrel.NotDropped || reason >= squirrel.NumDropReasons {
+			reason = squirrel.DroppedUnknown
+		}
+		atomic.AddUint64(&counters.Dropped[reason], 1)
+	}
+}
+
+// setData makes buf hold data, if an interceptor replaced the frame in buf
+// with data.
+func setData(buf *common.ReusableSlice, data []byte) {
+	if len(data) == len(buf.Slice()) && (len(data) == 0 || &data[0] == &buf.Slice()[0]) {
+		return
+	}
+	*buf.SlicePtr() = append(buf.Slice()[:0], data...)
+}
+
+// Inject handles data as if it was an Ethernet frame sent by source. It
+// implements squirrel.FrameInjector.
+func (master *Master) Inject(source int, data []byte) {
+	buf := master.wirePool.Get()
+	*buf.SlicePtr() = append(buf.Slice()[:0], data...)
+	master.decideDeferred(source, buf, master.clock.Now())
+}
+
+// AddInterceptor appends interceptor to the chain of interceptors that act
+// upon every frame. interceptor should be configured. It should be called
+// before Run.
+func (master *Master) AddInterceptor(interceptor squirrel.FrameInterceptor) {
+	if user, ok := interceptor.(squirrel.InjectorUser); ok {
+		user.SetInjector(master)
+	}
+	master.interceptors = append(master.interceptors, interceptor)
+}
+
+func (master *Master) frameHandler(myIdentity int, link *common.Link) {
 	var (
-		listener net.Listener
-		identity int
+		buf *common.ReusableSlice
+		ok  bool
+		s   *scratch
+		rng *rand.Rand
 	)
+	if master.medium == nil {
+		s = master.newScratch()
+		rng = master.newRand(int64(myIdentity))
+	}
+
+	for {
+		buf, ok = link.ReadFrame()
+		if !ok {
+			break
+		}
+		if master.medium != nil {
+			master.medium.send(myIdentity, buf, master.clock.Now())
+		} else {
+			master.handleFrame(myIdentity, buf, master.clock.Now(), s, rng)
+		}
+	}
+	master.clientLeave(myIdentity, link.IncomingError())
+}
 
+// ListenAndServeHTTP serves status endpoints of master on addr. It blocks until
+// the underlying listener fails.
+func (master *Master) ListenAndServeHTTP(addr string) error {
+	return http.ListenAndServe(addr, master.httpHandler())
+}
+
+// httpHandler returns a handler of status endpoints of master. Septembers that
+// implement http.Handler are served at /septembers/<channel> and paths under
+// it, with the prefix stripped, so that e.g. Composite can serve its stages.
+func (master *Master) httpHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/stats", master.stats)
+	mux.Handle("/pcap", master.streams)
+	mux.Handle("/acl", master.acl)
+	for _, ch := range master.channels.list {
+		if handler, ok := squirrel.Implementation(ch.september).(http.Handler); ok {
+			prefix := "/septembers/" + ch.name
+			mux.Handle(prefix, http.StripPrefix(prefix, handler))
+			mux.Handle(prefix+"/", http.StripPrefix(prefix, handler))
+		}
+	}
+	return mux
+}
+
+// Seed makes all randomness in master derived from seed. It should be called
+// before SerializeMedium and Run.
+func (master *Master) Seed(seed int64) {
+	master.seed = seed
+	master.seeded = true
+}
+
+// newRand returns a random number generator for a routine identified by id. If
+// master is seeded, it's seeded from master's seed and id, so that each
+// routine gets a reproducible sequence of its own.
+func (master *Master) newRand(id int64) *rand.Rand {
+	return rand.New(master.newRandSource(id))
+}
+
+func (master *Master) newRandSource(id int64) rand.Source {
+	if master.seeded {
+		return rand.NewSource(master.seed*1000003 + id)
+	}
+	return rand.NewSource(time.Now().UnixNano() + id)
+}
+
+// lockedSource is a rand.Source that is safe for concurrent use.
+type lockedSource struct {
+	src rand.Source
+	mu  sync.Mutex
+}
+
+func (s *lockedSource) Int63() (n int64) {
+	s.mu.Lock()
+	n = s.src.Int63()
+	s.mu.Unlock()
+	return
+}
+
+func (s *lockedSource) Seed(seed int64) {
+	s.mu.Lock()
+	s.src.Seed(seed)
+	s.mu.Unlock()
+}
+
+// deferredRand returns the random number generator for frames from source
+// handled out of its routine. There's one per source, safe for concurrent use,
+// so that a seeded run draws a single reproducible sequence for each source
+// rather than restarting the same one for every frame. Its id doesn't collide
+// with those of the routines of sources.
+func (master *Master) deferredRand(source int) *rand.Rand {
+	master.deferredRandsMu.Lock()
+	defer master.deferredRandsMu.Unlock()
+	rng, ok := master.deferredRands[source]
+	if !ok {
+		rng = rand.New(&lockedSource{src: master.newRandSource(-1 - int64(source))})
+		master.deferredRands[source] = rng
+	}
+	return rng
+}
+
+// RecordDecisions makes master record every decision of September into w. It
+// should be called before Run.
+func (master *Master) RecordDecisions(w io.Writer) {
+	master.recorder = newRecordingSeptember(master.decider, w, master.clock)
+	master.decider = master.recorder
+}
+
+// Close flushes and closes capture files, and what master is recording
+// decisions into, if anything. It should be called before exiting, so that
+// the last frames and decisions aren't lost.
+func (master *Master) Close() error {
+	master.capture.Close()
+	if master.recorder == nil {
+		return nil
+	}
+	return master.recorder.Close()
+}
+
+// ReplayDecisions makes master apply decisions recorded by RecordDecisions and
+// read from r, instead of asking September. It should be called before Run.
+func (master *Master) ReplayDecisions(r io.Reader) (err error) {
+	master.decider, err = newReplayingSeptember(master.decider, r)
+	return
+}
+
+// SerializeMedium makes master handle frames from all clients in a single
+// routine in order of their arrival, with each frame held back for window to
+// let frames that arrived earlier go ahead of it. It should be called before
+// Run.
+func (master *Master) SerializeMedium(window time.Duration) {
+	master.medium = newMedium(master, window)
+	go master.medium.run()
+}
+
+func (master *Master) Run(laddr string) (err error) {
+	var listener net.Listener
 	listener, err = net.Listen("tcp", laddr)
 	if err != nil {
 		return
 	}
 	for {
-		identity, err = master.accept(listener)
+		err = master.accept(listener)
 		if err != nil {
 			continue
 		}
-		go master.frameHandler(identity)
 	}
 	return
 }
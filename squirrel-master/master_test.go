@@ -0,0 +1,370 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/coreos/go-etcd/etcd"
+	"github.com/squirrel-land/squirrel"
+	"github.com/squirrel-land/squirrel/common"
+	"github.com/squirrel-land/squirrel/septembers"
+)
+
+func TestDeferredRand(t *testing.T) {
+	_, network, _ := net.ParseCIDR("10.0.0.0/24")
+	master := NewMaster(network, 1, stillMobility{}, &deliverEverywhere{})
+	master.seed, master.seeded = 42, true
+
+	rng := master.deferredRand(3)
+	if master.deferredRand(3) != rng {
+		t.Fatal("frames from the same source should share a generator")
+	}
+	first, second := rng.Int63(), rng.Int63()
+	if first == second {
+		t.Error("generator restarts its sequence")
+	}
+	if master.newRand(3).Int63() == first {
+		t.Error("generator draws the same sequence as the routine of its source")
+	}
+}
+
+// testNode is a node joined to master over a pipe, as a worker would be.
+// Frames delivered to it come out of frames.
+type testNode struct {
+	identity int
+	frames   chan []byte
+}
+
+// joinTestNodes joins n nodes to master, with identities 1 to n, and returns
+// them indexed by identity.
+func joinTestNodes(t *testing.T, master *Master, n int) []*testNode {
+	nodes := make([]*testNode, n+1)
+	for i := 1; i <= n; i++ {
+		conn, peer := net.Pipe()
+		link := common.NewLink(conn)
+		identity, err := master.clients.Add(&client{Link: link, Addr: nodeMAC(i)})
+		if err != nil || identity != i {
+			t.Fatalf("got identity %d (%v), expected %d", identity, err, i)
+		}
+		master.clientJoin(identity, nodeMAC(identity), "")
+		link.StartRoutines()
+		worker := common.NewLink(peer)
+		worker.StartRoutines()
+		node := &testNode{identity: identity, frames: make(chan []byte, 16)}
+		go func() {
+			for {
+				buf, ok := worker.ReadFrame()
+				if !ok {
+					return
+				}
+				node.frames <- append([]byte(nil), buf.Slice()...)
+				buf.Done()
+			}
+		}()
+		t.Cleanup(func() {
+			conn.Close()
+			peer.Close()
+		})
+		nodes[i] = node
+	}
+	return nodes
+}
+
+func (n *testNode) receive(t *testing.T) []byte {
+	t.Helper()
+	select {
+	case frame := <-n.frames:
+		return frame
+	case <-time.After(5 * time.Second):
+		t.Fatalf("node %d received no frame", n.identity)
+		return nil
+	}
+}
+
+func (n *testNode) expectNothing(t *testing.T) {
+	t.Helper()
+	select {
+	case frame := <-n.frames:
+		t.Errorf("node %d received a frame of length %d", n.identity, len(frame))
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// sendFrame has master handle data as if source sent it.
+func sendFrame(master *Master, source int, data []byte) {
+	buf := master.wirePool.Get()
+	*buf.SlicePtr() = append(buf.Slice()[:0], data...)
+	master.handleFrame(source, buf, master.clock.Now(), master.newScratch(), master.newRand(int64(source)))
+}
+
+// funcInterceptor is a FrameInterceptor whose hooks are the functions it
+// holds, if they're not nil.
+type funcInterceptor struct {
+	before   func(frame *squirrel.InterceptedFrame) bool
+	after    func(frame *squirrel.InterceptedFrame, recipients []int) []int
+	injector squirrel.FrameInjector
+}
+
+func (f *funcInterceptor) ParametersHelp() string     { return "" }
+func (f *funcInterceptor) Configure(*etcd.Node) error { return nil }
+
+func (f *funcInterceptor) BeforeDecision(frame *squirrel.InterceptedFrame) bool {
+	return f.before == nil || f.before(frame)
+}
+
+func (f *funcInterceptor) AfterDecision(frame *squirrel.InterceptedFrame, recipients []int) []int {
+	if f.after == nil {
+		return recipients
+	}
+	return f.after(frame, recipients)
+}
+
+func (f *funcInterceptor) SetInjector(injector squirrel.FrameInjector) {
+	f.injector = injector
+}
+
+func newInterceptedMaster(t *testing.T, interceptors ...squirrel.FrameInterceptor) (*Master, []*testNode) {
+	_, network, _ := net.ParseCIDR("10.0.0.0/24")
+	master := NewMaster(network, 1, stillMobility{}, &deliverEverywhere{})
+	for _, interceptor := range interceptors {
+		master.AddInterceptor(interceptor)
+	}
+	return master, joinTestNodes(t, master, 3)
+}
+
+var broadcastAddr = net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+
+func TestInterceptorDrop(t *testing.T) {
+	var seen int32
+	master, nodes := newInterceptedMaster(t,
+		&funcInterceptor{before: func(frame *squirrel.InterceptedFrame) bool { return frame.Source != 1 }},
+		&funcInterceptor{before: func(frame *squirrel.InterceptedFrame) bool {
+			atomic.AddInt32(&seen, 1)
+			return true
+		}},
+	)
+	sendFrame(master, 1, ethernetFrame(broadcastAddr, 0x0800, make([]byte, 46)))
+	nodes[2].expectNothing(t)
+	nodes[3].expectNothing(t)
+	if atomic.LoadInt32(&seen) != 0 {
+		t.Error("interceptor after the one that dropped the frame saw it")
+	}
+
+	sendFrame(master, 2, ethernetFrame(broadcastAddr, 0x0800, make([]byte, 46)))
+	nodes[1].receive(t)
+	nodes[3].receive(t)
+	if atomic.LoadInt32(&seen) != 1 {
+		t.Error("interceptor didn't see a frame that wasn't dropped")
+	}
+}
+
+func TestInterceptorModify(t *testing.T) {
+	master, nodes := newInterceptedMaster(t, &funcInterceptor{
+		before: func(frame *squirrel.InterceptedFrame) bool {
+			frame.Data[len(frame.Data)-1] = 0xaa
+			return true
+		},
+		after: func(frame *squirrel.InterceptedFrame, recipients []int) []int {
+			frame.Data = append(append([]byte(nil), frame.Data...), 0xbb)
+			return recipients
+		},
+	})
+	sent := ethernetFrame(nodeMAC(2), 0x0800, make([]byte, 46))
+	sendFrame(master, 1, sent)
+	got := nodes[2].receive(t)
+	if len(got) != len(sent)+1 || got[len(got)-2] != 0xaa || got[len(got)-1] != 0xbb {
+		t.Errorf("received a frame of length %d ending with % x, expected length %d ending with aa bb", len(got), got[len(got)-2:], len(sent)+1)
+	}
+}
+
+func TestInterceptorDelay(t *testing.T) {
+	const delay = 50 * time.Millisecond
+	master, nodes := newInterceptedMaster(t, &funcInterceptor{
+		before: func(frame *squirrel.InterceptedFrame) bool {
+			frame.Delay = delay
+			return true
+		},
+	})
+	start := time.Now()
+	sendFrame(master, 1, ethernetFrame(nodeMAC(2), 0x0800, make([]byte, 46)))
+	nodes[2].receive(t)
+	if elapsed := time.Since(start); elapsed < delay {
+		t.Errorf("frame delayed by %v arrived after %v", delay, elapsed)
+	}
+}
+
+func TestInterceptorAfterDecision(t *testing.T) {
+	type call struct {
+		destination int
+		recipients  []int
+	}
+	calls := make(chan call, 4)
+	master, nodes := newInterceptedMaster(t, &funcInterceptor{
+		after: func(frame *squirrel.InterceptedFrame, recipients []int) []int {
+			calls <- call{frame.Destination, append([]int(nil), recipients...)}
+			// keep node 3 only
+			kept := recipients[:0]
+			for _, id := range recipients {
+				if id == 3 {
+					kept = append(kept, id)
+				}
+			}
+			return kept
+		},
+	})
+
+	sendFrame(master, 1, ethernetFrame(broadcastAddr, 0x0800, make([]byte, 46)))
+	c := <-calls
+	sort.Ints(c.recipients)
+	if c.destination != 0 || !reflect.DeepEqual(c.recipients, []int{2, 3}) {
+		t.Errorf("broadcast frame: got destination %d and recipients %v, expected 0 and [2 3]", c.destination, c.recipients)
+	}
+	nodes[3].receive(t)
+	nodes[2].expectNothing(t)
+
+	sendFrame(master, 1, ethernetFrame(nodeMAC(2), 0x0800, make([]byte, 46)))
+	if c := <-calls; c.destination != 2 || !reflect.DeepEqual(c.recipients, []int{2}) {
+		t.Errorf("unicast frame: got destination %d and recipients %v, expected 2 and [2]", c.destination, c.recipients)
+	}
+	nodes[2].expectNothing(t)
+}
+
+func TestInterceptorInject(t *testing.T) {
+	var before int32
+	sources := make(chan int, 4)
+	interceptor := &funcInterceptor{
+		before: func(frame *squirrel.InterceptedFrame) bool {
+			atomic.AddInt32(&before, 1)
+			return true
+		},
+		after: func(frame *squirrel.InterceptedFrame, recipients []int) []int {
+			sources <- frame.Source
+			return recipients
+		},
+	}
+	_, nodes := newInterceptedMaster(t, interceptor)
+	if interceptor.injector == nil {
+		t.Fatal("interceptor is not given an injector")
+	}
+
+	data := ethernetFrame(broadcastAddr, 0x0800, make([]byte, 46))
+	interceptor.injector.Inject(2, data)
+	data[len(data)-1] = 0xaa // Inject copies data
+	for _, id := range []int{1, 3} {
+		if got := nodes[id].receive(t); got[len(got)-1] != 0 {
+			t.Errorf("node %d received a frame modified after it was injected", id)
+		}
+	}
+	nodes[2].expectNothing(t)
+	if source := <-sources; source != 2 {
+		t.Errorf("AfterDecision saw an injected frame from %d, expected 2", source)
+	}
+	if atomic.LoadInt32(&before) != 0 {
+		t.Error("BeforeDecision saw an injected frame")
+	}
+}
+
+func TestFeedbackFates(t *testing.T) {
+	master, september, _ := newFeedbackMaster(t, 3)
+	master.DropWhenQueueFull = true
+	// frames to 3 are filtered out after the decision
+	master.AddInterceptor(&funcInterceptor{
+		after: func(frame *squirrel.InterceptedFrame, recipients []int) []int {
+			kept := recipients[:0]
+			for _, id := range recipients {
+				if id != 3 {
+					kept = append(kept, id)
+				}
+			}
+			return kept
+		},
+	})
+	// 4 never drains its outgoing queue, and 5 isn't there
+	conn, peer := net.Pipe()
+	t.Cleanup(func() {
+		conn.Close()
+		peer.Close()
+	})
+	identity, err := master.clients.Add(&client{Link: common.NewLink(conn), Addr: nodeMAC(4)})
+	if err != nil || identity != 4 {
+		t.Fatalf("got identity %d (%v), expected 4", identity, err)
+	}
+	master.clientJoin(identity, nodeMAC(identity), "")
+	for i := 0; i < 64; i++ {
+		sendFrame(master, 1, ethernetFrame(nodeMAC(4), 0x0800, make([]byte, 46)))
+	}
+
+	september.results = []squirrel.Result{
+		{Destination: 2, Delivered: true},
+		{Destination: 3, Delivered: true},
+		{Destination: 4, Delivered: true},
+		{Destination: 5, Delivered: true},
+	}
+	sendFrame(master, 1, ethernetFrame(broadcastAddr, 0x0800, make([]byte, 46)))
+	expected := map[int]squirrel.Fate{
+		2: squirrel.FateWritten,
+		3: squirrel.FateFiltered,
+		4: squirrel.FateQueueFull,
+		5: squirrel.FateDestinationLeft,
+	}
+	got := make(map[int]squirrel.Fate)
+	for len(got) < len(expected) {
+		select {
+		case f := <-september.feedback:
+			if f.Source != 1 {
+				t.Errorf("got feedback on a frame from %d, expected 1", f.Source)
+			}
+			if _, ok := got[f.Destination]; ok {
+				t.Errorf("got more than one fate of the frame to %d", f.Destination)
+			}
+			got[f.Destination] = f.Fate
+		case <-time.After(5 * time.Second):
+			t.Fatalf("got fates %v, expected %v", got, expected)
+		}
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("got fates %v, expected %v", got, expected)
+	}
+	if l := linkSnapshot(master, 1, 4); l.Dropped["queue_full"] != 1 {
+		t.Errorf("%d frames to 4 counted as dropped for a full queue, expected 1", l.Dropped["queue_full"])
+	}
+}
+
+func TestHTTPHandlerServesStages(t *testing.T) {
+	composite := septembers.NewComposite(newSeptember)
+	stages := dir("/c/stages",
+		dir("/c/stages/loss", value("/c/stages/loss/september", "link_matrix")),
+		dir("/c/stages/bursts", value("/c/stages/bursts/september", "gilbert_elliott"),
+			dir("/c/stages/bursts/config", value("/c/stages/bursts/config/p_good_to_bad", "0"), value("/c/stages/bursts/config/p_bad_to_good", "1"))),
+	)
+	if err := composite.Configure(dir("/c", stages)); err != nil {
+		t.Fatal(err)
+	}
+	_, network, _ := net.ParseCIDR("10.0.0.0/24")
+	handler := NewMaster(network, 1, stillMobility{}, composite).httpHandler()
+
+	tests := []struct {
+		path string
+		code int
+		body string
+	}{
+		{"/septembers/default/bursts", http.StatusOK, "[]\n"},
+		{"/septembers/default/loss", http.StatusNotFound, ""},
+		{"/septembers/default", http.StatusOK, `"september": "gilbert_elliott"`},
+		{"/septembers/default/", http.StatusOK, `"september": "gilbert_elliott"`},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "http://master"+test.path, nil))
+		if w.Code != test.code || !strings.Contains(w.Body.String(), test.body) {
+			t.Errorf("%s: got %d %q, expected %d with %q", test.path, w.Code, w.Body.String(), test.code, test.body)
+		}
+	}
+}
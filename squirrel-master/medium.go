@@ -0,0 +1,138 @@
+package main
+
+import (
+	"container/heap"
+	"sync/atomic"
+	"time"
+
+	"github.com/squirrel-land/squirrel/common"
+)
+
+type mediumFrame struct {
+	source  int
+	buf     *common.ReusableSlice
+	arrival time.Time
+	seq     uint64
+
+	// intercepted is true for a frame that interceptors delayed, after they
+	// acted upon it. arrival is then when the delay is over, and received is
+	// when it was read.
+	intercepted bool
+	received    time.Time
+}
+
+// mediumQueue is a min-heap of frames ordered by arrival time. Frames that
+// arrived at the same time are ordered by when they were read.
+type mediumQueue []*mediumFrame
+
+func (q mediumQueue) Len() int { return len(q) }
+func (q mediumQueue) Less(i, j int) bool {
+	if q[i].arrival.Equal(q[j].arrival) {
+		return q[i].seq < q[j].seq
+	}
+	return q[i].arrival.Before(q[j].arrival)
+}
+func (q mediumQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *mediumQueue) Push(x interface{}) { *q = append(*q, x.(*mediumFrame)) }
+func (q *mediumQueue) Pop() interface{} {
+	old := *q
+	f := old[len(old)-1]
+	old[len(old)-1] = nil
+	*q = old[:len(old)-1]
+	return f
+}
+
+// medium serializes frames from all clients into a single routine, which hands
+// them over to master in the order they arrived. This way September sees
+// frames one at a time in a reproducible order, rather than from concurrent
+// frameHandler routines in whatever order the scheduler picks.
+//
+// Frames read concurrently may reach medium slightly out of order. A frame is
+// held back for window (of emulated time) after its arrival, so that frames that arrived
+// earlier but are late to reach medium get a chance to go ahead of it. Frames
+// that interceptors delay are held in medium too, as if they arrived when the
+// delay is over.
+type medium struct {
+	master *Master
+	window time.Duration
+	frames chan *mediumFrame
+	seq    uint64 // accessed atomically
+
+	// held are frames delayed by interceptors while run was handling a frame;
+	// only accessed by run.
+	held []*mediumFrame
+}
+
+func newMedium(master *Master, window time.Duration) *medium {
+	return &medium{
+		master: master,
+		window: window,
+		frames: make(chan *mediumFrame, 1024),
+	}
+}
+
+// send queues frame in buf from source, which arrived at arrival. medium takes
+// over the ownership of buf.
+func (m *medium) send(source int, buf *common.ReusableSlice, arrival time.Time) {
+	m.frames <- &mediumFrame{
+		source:  source,
+		buf:     buf,
+		arrival: arrival,
+		seq:     atomic.AddUint64(&m.seq, 1),
+	}
+}
+
+// hold queues frame in buf from source, read at received, which interceptors
+// delayed until due, so that it's decided upon in order with other frames
+// rather than from a timer. It must be called from run, i.e. while master
+// handles a frame from medium. medium takes over the ownership of buf.
+func (m *medium) hold(source int, buf *common.ReusableSlice, received time.Time, due time.Time) {
+	m.held = append(m.held, &mediumFrame{
+		source:      source,
+		buf:         buf,
+		arrival:     due,
+		seq:         atomic.AddUint64(&m.seq, 1),
+		intercepted: true,
+		received:    received,
+	})
+}
+
+func (m *medium) run() {
+	var (
+		pending mediumQueue
+		timer   = time.NewTimer(time.Hour)
+		s       = m.master.newScratch()
+		rng     = m.master.newRand(0)
+	)
+	timer.Stop()
+	for {
+		if len(pending) == 0 {
+			heap.Push(&pending, <-m.frames)
+			continue
+		}
+		wait := m.master.clock.wall(pending[0].arrival.Add(m.window).Sub(m.master.clock.Now()))
+		if wait <= 0 {
+			f := heap.Pop(&pending).(*mediumFrame)
+			if f.intercepted {
+				m.master.decide(f.source, f.buf, f.received, s, rng)
+			} else {
+				m.master.handleFrame(f.source, f.buf, f.arrival, s, rng)
+			}
+			for i, held := range m.held {
+				heap.Push(&pending, held)
+				m.held[i] = nil
+			}
+			m.held = m.held[:0]
+			continue
+		}
+		timer.Reset(wait)
+		select {
+		case f := <-m.frames:
+			heap.Push(&pending, f)
+			if !timer.Stop() {
+				<-timer.C
+			}
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/coreos/go-etcd/etcd"
+	"github.com/squirrel-land/squirrel"
+	"github.com/squirrel-land/squirrel/common"
+)
+
+// sequenceSeptember tells the order it's asked about broadcast frames in, by
+// their sources, and whether it's ever asked concurrently.
+type sequenceSeptember struct {
+	deliverEverywhere
+	sources    chan int
+	busy       time.Duration // how long each decision takes
+	inFlight   int32
+	concurrent int32
+}
+
+func (s *sequenceSeptember) SendBroadcast(source int, frame *squirrel.Frame, underlying []squirrel.Result) []squirrel.Result {
+	if atomic.AddInt32(&s.inFlight, 1) > 1 {
+		atomic.StoreInt32(&s.concurrent, 1)
+	}
+	time.Sleep(s.busy)
+	atomic.AddInt32(&s.inFlight, -1)
+	s.sources <- source
+	return underlying[:0]
+}
+
+// delayInterceptor delays frames from source.
+type delayInterceptor struct {
+	source int
+	delay  time.Duration
+}
+
+func (d *delayInterceptor) ParametersHelp() string     { return "" }
+func (d *delayInterceptor) Configure(*etcd.Node) error { return nil }
+func (d *delayInterceptor) BeforeDecision(frame *squirrel.InterceptedFrame) bool {
+	if frame.Source == d.source {
+		frame.Delay = d.delay
+	}
+	return true
+}
+func (d *delayInterceptor) AfterDecision(frame *squirrel.InterceptedFrame, recipients []int) []int {
+	return recipients
+}
+
+func newMediumMaster(september *sequenceSeptember, window time.Duration) *Master {
+	_, network, _ := net.ParseCIDR("10.0.0.0/24")
+	master := NewMaster(network, 1, stillMobility{}, september)
+	master.SerializeMedium(window)
+	return master
+}
+
+// broadcastBuf returns a broadcast frame in a buffer of master.
+func broadcastBuf(master *Master) *common.ReusableSlice {
+	buf := master.wirePool.Get()
+	*buf.SlicePtr() = ethernetFrame(net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, 0x0800, make([]byte, 46))
+	return buf
+}
+
+func receiveSources(t *testing.T, sources chan int, n int) (ret []int) {
+	for i := 0; i < n; i++ {
+		select {
+		case source := <-sources:
+			ret = append(ret, source)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("only %d of %d frames decided upon", i, n)
+		}
+	}
+	return
+}
+
+func TestMediumOrdersByArrival(t *testing.T) {
+	const window = 50 * time.Millisecond
+	september := &sequenceSeptember{sources: make(chan int, 8)}
+	master := newMediumMaster(september, window)
+	now := master.clock.Now()
+	// frames reach medium in another order than they arrived
+	master.medium.send(3, broadcastBuf(master), now.Add(20*time.Millisecond))
+	master.medium.send(1, broadcastBuf(master), now)
+	master.medium.send(2, broadcastBuf(master), now.Add(10*time.Millisecond))
+
+	sources := receiveSources(t, september.sources, 3)
+	if elapsed := master.clock.Now().Sub(now); elapsed < 20*time.Millisecond+window {
+		t.Errorf("last frame decided upon after %v, before its window was over", elapsed)
+	}
+	if sources[0] != 1 || sources[1] != 2 || sources[2] != 3 {
+		t.Errorf("frames decided upon in order %v, expected [1 2 3]", sources)
+	}
+}
+
+func TestMediumHoldsDelayedFrames(t *testing.T) {
+	const window = 5 * time.Millisecond
+	september := &sequenceSeptember{sources: make(chan int, 8), busy: 20 * time.Millisecond}
+	master := newMediumMaster(september, window)
+	master.AddInterceptor(&delayInterceptor{source: 1, delay: 30 * time.Millisecond})
+	now := master.clock.Now()
+	// 1 is delayed until now+30ms, after 2; 2 is still being decided upon
+	// when 1 is due
+	master.medium.send(1, broadcastBuf(master), now)
+	master.medium.send(2, broadcastBuf(master), now.Add(25*time.Millisecond))
+
+	sources := receiveSources(t, september.sources, 2)
+	if sources[0] != 2 || sources[1] != 1 {
+		t.Errorf("frames decided upon in order %v, expected [2 1]", sources)
+	}
+	if atomic.LoadInt32(&september.concurrent) != 0 {
+		t.Error("September was asked concurrently")
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/squirrel-land/squirrel/common"
+)
+
+// monitors keeps links to clients that joined as monitors. Each frame that
+// goes through master is sent to all of them, encapsulated along with
+// identities of its source, destination and recipients. A monitor that can't
+// keep up misses frames, rather than holding back forwarding; they're counted
+// in stats.
+type monitors struct {
+	// links is replaced rather than changed in place, since send keeps using it
+	// after releasing mu.
+	links []*common.Link
+	mu    sync.RWMutex
+	pool  *common.SlicePool
+	stats *stats
+}
+
+func newMonitors(stats *stats) *monitors {
+	return &monitors{pool: common.NewSlicePool(1700), stats: stats}
+}
+
+func (m *monitors) add(link *common.Link) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	links := make([]*common.Link, 0, len(m.links)+1)
+	m.links = append(append(links, m.links...), link)
+}
+
+func (m *monitors) remove(link *common.Link) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	links := make([]*common.Link, 0, len(m.links))
+	for _, l := range m.links {
+		if l != link {
+			links = append(links, l)
+		}
+	}
+	m.links = links
+}
+
+// send sends frame from source to destination, which September decided to be
+// delivered to recipients, to all monitors. destination is 0 if frame is
+// addressed to a group or an unknown address.
+func (m *monitors) send(frame []byte, source int, destination int, recipients []int) {
+	m.mu.RLock()
+	links := m.links
+	m.mu.RUnlock()
+	if len(links) == 0 {
+		return
+	}
+	raw := m.pool.Get()
+	*raw.SlicePtr() = common.AppendMonitorFrame(raw.Slice()[:0], frame, source, destination, recipients)
+	encoded := m.pool.Get()
+	*encoded.SlicePtr() = common.AppendEncodedFrame(encoded.Slice()[:0], raw.Slice())
+	raw.Done()
+	for _, link := range links {
+		encoded.AddOwner()
+		if !link.TryWriteEncodedFrame(encoded, nil) {
+			encoded.Done()
+			atomic.AddUint64(&m.stats.MonitorDropped, 1)
+		}
+	}
+	encoded.Done()
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/squirrel-land/squirrel/common"
+)
+
+// monitorLink returns a started Link whose peer discards whatever is written
+// to it.
+func monitorLink() *common.Link {
+	conn, peer := net.Pipe()
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			if _, err := peer.Read(buf); err != nil {
+				return
+			}
+		}
+	}()
+	link := common.NewLink(conn)
+	link.StartRoutines()
+	return link
+}
+
+func TestMonitorsAddRemove(t *testing.T) {
+	m := newMonitors(newStats())
+	links := []*common.Link{monitorLink(), monitorLink(), monitorLink()}
+	for _, link := range links {
+		m.add(link)
+	}
+	snapshot := m.links
+	m.remove(links[1])
+	if len(m.links) != 2 || m.links[0] != links[0] || m.links[1] != links[2] {
+		t.Errorf("links are %v after removing the second one", m.links)
+	}
+	// a snapshot taken before is left intact
+	for i, link := range links {
+		if snapshot[i] != link {
+			t.Errorf("snapshot changed at %d", i)
+		}
+	}
+}
+
+func TestMonitorsConcurrent(t *testing.T) {
+	m := newMonitors(newStats())
+	frame := make([]byte, 60)
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			link := monitorLink()
+			m.add(link)
+			m.remove(link)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 500; i++ {
+			m.send(frame, 1, 0, []int{2})
+		}
+	}()
+	wg.Wait()
+	if len(m.links) != 0 {
+		t.Errorf("%d links left", len(m.links))
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/binary"
+	"log"
+	"net"
+	"sync"
+
+	"github.com/songgao/packets/ethernet"
+)
+
+type groupAddr [6]byte
+
+// multicastGroups keeps track of multicast group memberships of identities by
+// snooping IGMP (IPv4) and MLD (IPv6) messages they send. Since the emulated
+// network has no querier, memberships don't expire; they're only removed by
+// leave/done messages or when the identity leaves.
+type multicastGroups struct {
+	members map[groupAddr]map[int]bool
+	mu      sync.RWMutex
+}
+
+func newMulticastGroups() *multicastGroups {
+	return &multicastGroups{members: make(map[groupAddr]map[int]bool)}
+}
+
+func (g *multicastGroups) join(identity int, group groupAddr) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	m, ok := g.members[group]
+	if !ok {
+		m = make(map[int]bool)
+		g.members[group] = m
+	}
+	m[identity] = true
+	if *debug {
+		log.Printf("client %d joined multicast group %v\n", identity, net.HardwareAddr(group[:]))
+	}
+}
+
+func (g *multicastGroups) leave(identity int, group groupAddr) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if m, ok := g.members[group]; ok {
+		delete(m, identity)
+		if len(m) == 0 {
+			delete(g.members, group)
+		}
+	}
+	if *debug {
+		log.Printf("client %d left multicast group %v\n", identity, net.HardwareAddr(group[:]))
+	}
+}
+
+// leaveAll removes identity from all groups it has joined.
+func (g *multicastGroups) leaveAll(identity int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for group, m := range g.members {
+		delete(m, identity)
+		if len(m) == 0 {
+			delete(g.members, group)
+		}
+	}
+}
+
+// filter removes identities that are not members of group from recipients, in
+// place, and returns the sub-slice of members.
+func (g *multicastGroups) filter(group net.HardwareAddr, recipients []int) []int {
+	var key groupAddr
+	copy(key[:], group)
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	m := g.members[key]
+	n := 0
+	for _, id := range recipients {
+		if m[id] {
+			recipients[n] = id
+			n++
+		}
+	}
+	return recipients[:n]
+}
+
+// isSnoopedGroup returns whether delivery of frames to group is restricted to
+// snooped members. Only IP multicast groups are snooped, except those that are
+// used without any report being sent for them: 224.0.0.0/24 for IPv4 (RFC
+// 4541), and ff02::X and solicited-node addresses for IPv6, which neighbour
+// discovery relies on before MLD reports get a chance to be sent.
+func isSnoopedGroup(group net.HardwareAddr) bool {
+	if isIPv4Multicast(group) {
+		return group[3] != 0x00 || group[4] != 0x00
+	}
+	if isIPv6Multicast(group) {
+		return group[2] != 0xff && (group[2] != 0x00 || group[3] != 0x00 || group[4] != 0x00)
+	}
+	return false
+}
+
+func ipv4GroupAddr(ip []byte) (group groupAddr) {
+	return groupAddr{0x01, 0x00, 0x5e, ip[1] & 0x7f, ip[2], ip[3]}
+}
+
+func ipv6GroupAddr(ip []byte) (group groupAddr) {
+	return groupAddr{0x33, 0x33, ip[12], ip[13], ip[14], ip[15]}
+}
+
+// snoop updates memberships of identity if frame carries an IGMP or MLD
+// message.
+func (g *multicastGroups) snoop(identity int, frame ethernet.Frame) {
+	if len(frame) < 14 || len(frame) < 14+int(frame.Tagging()) {
+		return
+	}
+	switch frame.Ethertype() {
+	case ethernet.IPv4:
+		g.snoopIPv4(identity, frame.Payload())
+	case ethernet.IPv6:
+		g.snoopIPv6(identity, frame.Payload())
+	}
+}
+
+func (g *multicastGroups) snoopIPv4(identity int, packet []byte) {
+	if len(packet) < 20 || packet[9] != 2 { // IGMP
+		return
+	}
+	ihl := int(packet[0]&0x0f) * 4
+	if len(packet) < ihl+8 {
+		return
+	}
+	igmp := packet[ihl:]
+	switch igmp[0] {
+	case 0x12, 0x16: // v1/v2 membership report
+		g.join(identity, ipv4GroupAddr(igmp[4:8]))
+	case 0x17: // v2 leave group
+		g.leave(identity, ipv4GroupAddr(igmp[4:8]))
+	case 0x22: // v3 membership report
+		records := int(binary.BigEndian.Uint16(igmp[6:8]))
+		rec := igmp[8:]
+		for i := 0; i < records && len(rec) >= 8; i++ {
+			sources := int(binary.BigEndian.Uint16(rec[2:4]))
+			g.applyRecord(identity, rec[0], sources, ipv4GroupAddr(rec[4:8]))
+			length := 8 + 4*sources + 4*int(rec[1])
+			if len(rec) < length {
+				return
+			}
+			rec = rec[length:]
+		}
+	}
+}
+
+func (g *multicastGroups) snoopIPv6(identity int, packet []byte) {
+	if len(packet) < 40 {
+		return
+	}
+	next := packet[6]
+	packet = packet[40:]
+	// skip extension headers; MLD messages carry a hop-by-hop header
+	for next == 0 || next == 43 || next == 60 {
+		if len(packet) < 8 || len(packet) < (int(packet[1])+1)*8 {
+			return
+		}
+		next, packet = packet[0], packet[(int(packet[1])+1)*8:]
+	}
+	if next != 58 || len(packet) < 8 { // ICMPv6
+		return
+	}
+	switch packet[0] {
+	case 131: // MLDv1 report
+		if len(packet) >= 24 {
+			g.join(identity, ipv6GroupAddr(packet[8:24]))
+		}
+	case 132: // MLDv1 done
+		if len(packet) >= 24 {
+			g.leave(identity, ipv6GroupAddr(packet[8:24]))
+		}
+	case 143: // MLDv2 report
+		records := int(binary.BigEndian.Uint16(packet[6:8]))
+		rec := packet[8:]
+		for i := 0; i < records && len(rec) >= 20; i++ {
+			sources := int(binary.BigEndian.Uint16(rec[2:4]))
+			g.applyRecord(identity, rec[0], sources, ipv6GroupAddr(rec[4:20]))
+			length := 20 + 16*sources + 4*int(rec[1])
+			if len(rec) < length {
+				return
+			}
+			rec = rec[length:]
+		}
+	}
+}
+
+// applyRecord applies an IGMPv3/MLDv2 group record. Source filtering is not
+// emulated, so a group is considered joined unless the record says no source
+// is wanted.
+func (g *multicastGroups) applyRecord(identity int, recordType byte, sources int, group groupAddr) {
+	switch recordType {
+	case 1, 3: // MODE_IS_INCLUDE, CHANGE_TO_INCLUDE_MODE
+		if sources == 0 {
+			g.leave(identity, group)
+		} else {
+			g.join(identity, group)
+		}
+	case 2, 4, 5: // MODE_IS_EXCLUDE, CHANGE_TO_EXCLUDE_MODE, ALLOW_NEW_SOURCES
+		g.join(identity, group)
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+// ethernetFrame returns a frame to group carrying packet with etherType.
+func ethernetFrame(group net.HardwareAddr, etherType uint16, packet []byte) []byte {
+	frame := append([]byte{}, group...)
+	frame = append(frame, 0x02, 0, 0, 0, 0, 1, byte(etherType>>8), byte(etherType))
+	return append(frame, packet...)
+}
+
+// igmp returns an IPv4 frame carrying an IGMP message.
+func igmp(message ...byte) []byte {
+	header := []byte{0x45, 0, 0, 0, 0, 0, 0, 0, 1, 2, 0, 0, 10, 0, 0, 1, 224, 0, 0, 22}
+	return ethernetFrame(net.HardwareAddr{0x01, 0x00, 0x5e, 0x00, 0x00, 0x16}, 0x0800, append(header, message...))
+}
+
+// mld returns an IPv6 frame carrying an MLD message after a hop-by-hop header.
+func mld(message ...byte) []byte {
+	header := make([]byte, 40)
+	header[0], header[6], header[7] = 0x60, 0, 1 // next header: hop-by-hop
+	hopByHop := []byte{58, 0, 5, 2, 0, 0, 1, 0}
+	packet := append(append(header, hopByHop...), message...)
+	return ethernetFrame(net.HardwareAddr{0x33, 0x33, 0x00, 0x00, 0x00, 0x16}, 0x86dd, packet)
+}
+
+func ipv6Group(last byte) []byte {
+	return []byte{0xff, 0x05, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x01, last}
+}
+
+func TestMulticastSnooping(t *testing.T) {
+	v4Group := net.HardwareAddr{0x01, 0x00, 0x5e, 0x01, 0x02, 0x03}
+	v6Group := net.HardwareAddr{0x33, 0x33, 0x00, 0x00, 0x01, 0x09}
+	type sent struct {
+		identity int
+		frame    []byte
+	}
+	tests := []struct {
+		name     string
+		sent     []sent
+		group    net.HardwareAddr
+		expected []int
+	}{
+		{
+			name:     "IGMPv2 report",
+			sent:     []sent{{1, igmp(0x16, 0, 0, 0, 239, 1, 2, 3)}, {3, igmp(0x16, 0, 0, 0, 239, 1, 2, 3)}},
+			group:    v4Group,
+			expected: []int{1, 3},
+		},
+		{
+			name:     "IGMPv2 leave",
+			sent:     []sent{{1, igmp(0x16, 0, 0, 0, 239, 1, 2, 3)}, {3, igmp(0x16, 0, 0, 0, 239, 1, 2, 3)}, {1, igmp(0x17, 0, 0, 0, 239, 1, 2, 3)}},
+			group:    v4Group,
+			expected: []int{3},
+		},
+		{
+			name:     "IGMPv2 report for another group",
+			sent:     []sent{{1, igmp(0x16, 0, 0, 0, 239, 1, 2, 4)}},
+			group:    v4Group,
+			expected: []int{},
+		},
+		{
+			name: "IGMPv3 records",
+			sent: []sent{
+				// CHANGE_TO_EXCLUDE_MODE with no sources: join
+				{2, igmp(0x22, 0, 0, 0, 0, 0, 0, 1, 4, 0, 0, 0, 239, 1, 2, 3)},
+				// CHANGE_TO_EXCLUDE_MODE, then CHANGE_TO_INCLUDE_MODE with no sources: leave
+				{3, igmp(0x22, 0, 0, 0, 0, 0, 0, 1, 4, 0, 0, 0, 239, 1, 2, 3)},
+				{3, igmp(0x22, 0, 0, 0, 0, 0, 0, 1, 3, 0, 0, 0, 239, 1, 2, 3)},
+			},
+			group:    v4Group,
+			expected: []int{2},
+		},
+		{
+			name: "MLDv1 report and done",
+			sent: []sent{
+				{1, mld(append([]byte{131, 0, 0, 0, 0, 0, 0, 0}, ipv6Group(0x09)...)...)},
+				{2, mld(append([]byte{131, 0, 0, 0, 0, 0, 0, 0}, ipv6Group(0x09)...)...)},
+				{2, mld(append([]byte{132, 0, 0, 0, 0, 0, 0, 0}, ipv6Group(0x09)...)...)},
+			},
+			group:    v6Group,
+			expected: []int{1},
+		},
+		{
+			name:     "MLDv2 record",
+			sent:     []sent{{4, mld(append([]byte{143, 0, 0, 0, 0, 0, 0, 1, 4, 0, 0, 0}, ipv6Group(0x09)...)...)}},
+			group:    v6Group,
+			expected: []int{4},
+		},
+		{
+			name:     "truncated",
+			sent:     []sent{{1, igmp(0x16, 0, 0)}, {2, mld(131, 0, 0, 0, 0, 0, 0, 0, 0xff)}, {3, []byte{0x01, 0x00}}},
+			group:    v4Group,
+			expected: []int{},
+		},
+	}
+	for _, test := range tests {
+		g := newMulticastGroups()
+		for _, s := range test.sent {
+			g.snoop(s.identity, s.frame)
+		}
+		if got := g.filter(test.group, []int{1, 2, 3, 4}); !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("%s: members are %v, expected %v", test.name, got, test.expected)
+		}
+	}
+}
+
+func TestMulticastLeaveAll(t *testing.T) {
+	g := newMulticastGroups()
+	g.snoop(1, igmp(0x16, 0, 0, 0, 239, 1, 2, 3))
+	g.snoop(2, igmp(0x16, 0, 0, 0, 239, 1, 2, 3))
+	g.leaveAll(1)
+	if got := g.filter(net.HardwareAddr{0x01, 0x00, 0x5e, 0x01, 0x02, 0x03}, []int{1, 2}); !reflect.DeepEqual(got, []int{2}) {
+		t.Errorf("members are %v, expected [2]", got)
+	}
+}
+
+func TestIsSnoopedGroup(t *testing.T) {
+	tests := []struct {
+		group    net.HardwareAddr
+		expected bool
+	}{
+		{net.HardwareAddr{0x01, 0x00, 0x5e, 0x01, 0x02, 0x03}, true},  // 239.1.2.3
+		{net.HardwareAddr{0x01, 0x00, 0x5e, 0x00, 0x00, 0xfb}, false}, // 224.0.0.251
+		{net.HardwareAddr{0x33, 0x33, 0x00, 0x00, 0x01, 0x09}, true},
+		{net.HardwareAddr{0x33, 0x33, 0x00, 0x00, 0x00, 0x01}, false}, // ff02::1
+		{net.HardwareAddr{0x33, 0x33, 0xff, 0x12, 0x34, 0x56}, false}, // solicited-node
+		{net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, false},
+	}
+	for _, test := range tests {
+		if got := isSnoopedGroup(test.group); got != test.expected {
+			t.Errorf("isSnoopedGroup(%v) is %v, expected %v", test.group, got, test.expected)
+		}
+	}
+}
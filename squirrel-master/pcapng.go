@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/binary"
+	"io"
+	"time"
+)
+
+// pcapngWriter writes a pcapng file (https://github.com/pcapng/pcapng) with a
+// single section, that has a single Ethernet interface.
+type pcapngWriter struct {
+	w       io.Writer
+	written int64
+	buf     []byte
+}
+
+const (
+	pcapngSectionHeaderBlock     = 0x0a0d0d0a
+	pcapngInterfaceDescBlock     = 0x00000001
+	pcapngEnhancedPacketBlock    = 0x00000006
+	pcapngByteOrderMagic         = 0x1a2b3c4d
+	pcapngLinkTypeEthernet       = 1
+	pcapngOptionEndOfOpt         = 0
+	pcapngOptionComment          = 1
+	pcapngOptionInterfaceTsResol = 9
+)
+
+var pcapngByteOrder = binary.LittleEndian
+
+func newPcapngWriter(w io.Writer) (p *pcapngWriter, err error) {
+	p = &pcapngWriter{w: w}
+	if err = p.writeSectionHeader(); err != nil {
+		return
+	}
+	err = p.writeInterfaceDescription()
+	return
+}
+
+// Written returns the number of bytes written so far.
+func (p *pcapngWriter) Written() int64 {
+	return p.written
+}
+
+func (p *pcapngWriter) putUint16(v uint16) {
+	var b [2]byte
+	pcapngByteOrder.PutUint16(b[:], v)
+	p.buf = append(p.buf, b[:]...)
+}
+
+func (p *pcapngWriter) putUint32(v uint32) {
+	var b [4]byte
+	pcapngByteOrder.PutUint32(b[:], v)
+	p.buf = append(p.buf, b[:]...)
+}
+
+func (p *pcapngWriter) pad() {
+	for len(p.buf)%4 != 0 {
+		p.buf = append(p.buf, 0)
+	}
+}
+
+func (p *pcapngWriter) putOption(code uint16, value []byte) {
+	p.putUint16(code)
+	p.putUint16(uint16(len(value)))
+	p.buf = append(p.buf, value...)
+	p.pad()
+}
+
+// beginBlock starts a block in p.buf; endBlock fills in the lengths and writes
+// it out.
+func (p *pcapngWriter) beginBlock(blockType uint32) {
+	p.buf = p.buf[:0]
+	p.putUint32(blockType)
+	p.putUint32(0) // block total length, filled in by endBlock
+}
+
+func (p *pcapngWriter) endBlock() (err error) {
+	p.putUint32(0)
+	length := uint32(len(p.buf))
+	pcapngByteOrder.PutUint32(p.buf[4:8], length)
+	pcapngByteOrder.PutUint32(p.buf[len(p.buf)-4:], length)
+	var n int
+	n, err = p.w.Write(p.buf)
+	p.written += int64(n)
+	return
+}
+
+func (p *pcapngWriter) writeSectionHeader() error {
+	p.beginBlock(pcapngSectionHeaderBlock)
+	p.putUint32(pcapngByteOrderMagic)
+	p.putUint16(1)          // major version
+	p.putUint16(0)          // minor version
+	p.putUint32(0xffffffff) // section length: unspecified
+	p.putUint32(0xffffffff)
+	return p.endBlock()
+}
+
+func (p *pcapngWriter) writeInterfaceDescription() error {
+	p.beginBlock(pcapngInterfaceDescBlock)
+	p.putUint16(pcapngLinkTypeEthernet)
+	p.putUint16(0)                                       // reserved
+	p.putUint32(0)                                       // snap length: no limit
+	p.putOption(pcapngOptionInterfaceTsResol, []byte{9}) // nanoseconds
+	p.putOption(pcapngOptionEndOfOpt, nil)
+	return p.endBlock()
+}
+
+// WritePacket writes frame captured at t, with comment attached if it's not
+// empty.
+func (p *pcapngWriter) WritePacket(t time.Time, frame []byte, comment string) error {
+	ts := uint64(t.UnixNano())
+	p.beginBlock(pcapngEnhancedPacketBlock)
+	p.putUint32(0) // interface ID
+	p.putUint32(uint32(ts >> 32))
+	p.putUint32(uint32(ts))
+	p.putUint32(uint32(len(frame)))
+	p.putUint32(uint32(len(frame)))
+	p.buf = append(p.buf, frame...)
+	p.pad()
+	if comment != "" {
+		p.putOption(pcapngOptionComment, []byte(comment))
+		p.putOption(pcapngOptionEndOfOpt, nil)
+	}
+	return p.endBlock()
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/coreos/go-etcd/etcd"
+)
+
+type pcapngBlock struct {
+	blockType uint32
+	body      []byte
+}
+
+// readPcapngBlocks splits a little-endian pcapng file into blocks, checking
+// their lengths.
+func readPcapngBlocks(t *testing.T, data []byte) (blocks []pcapngBlock) {
+	for len(data) > 0 {
+		if len(data) < 12 {
+			t.Fatalf("truncated block: %x", data)
+		}
+		length := binary.LittleEndian.Uint32(data[4:8])
+		if length%4 != 0 || length < 12 || int(length) > len(data) {
+			t.Fatalf("invalid block length %d", length)
+		}
+		if trailing := binary.LittleEndian.Uint32(data[length-4 : length]); trailing != length {
+			t.Fatalf("trailing block length %d doesn't match %d", trailing, length)
+		}
+		blocks = append(blocks, pcapngBlock{blockType: binary.LittleEndian.Uint32(data[0:4]), body: data[8 : length-4]})
+		data = data[length:]
+	}
+	return
+}
+
+func TestPcapngWriter(t *testing.T) {
+	var buf bytes.Buffer
+	p, err := newPcapngWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := time.Unix(1500000000, 123456789)
+	packets := []struct {
+		frame   []byte
+		comment string
+	}{
+		{frame: []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}, comment: "source=1 destination=2 delivered recipients=2"},
+		{frame: []byte{1, 2, 3, 4}, comment: ""},
+	}
+	for _, packet := range packets {
+		if err = p.WritePacket(at, packet.frame, packet.comment); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if p.Written() != int64(buf.Len()) {
+		t.Errorf("Written is %d, expected %d", p.Written(), buf.Len())
+	}
+
+	blocks := readPcapngBlocks(t, buf.Bytes())
+	if len(blocks) != 2+len(packets) {
+		t.Fatalf("%d blocks, expected %d", len(blocks), 2+len(packets))
+	}
+	if blocks[0].blockType != pcapngSectionHeaderBlock || binary.LittleEndian.Uint32(blocks[0].body[0:4]) != pcapngByteOrderMagic {
+		t.Errorf("first block is not a little-endian section header: %x", blocks[0])
+	}
+	if blocks[1].blockType != pcapngInterfaceDescBlock || binary.LittleEndian.Uint16(blocks[1].body[0:2]) != pcapngLinkTypeEthernet {
+		t.Errorf("second block is not an Ethernet interface description: %x", blocks[1])
+	}
+	for i, packet := range packets {
+		b := blocks[2+i]
+		if b.blockType != pcapngEnhancedPacketBlock {
+			t.Errorf("packet %d: block type is %x", i, b.blockType)
+			continue
+		}
+		ts := uint64(binary.LittleEndian.Uint32(b.body[4:8]))<<32 | uint64(binary.LittleEndian.Uint32(b.body[8:12]))
+		if ts != uint64(at.UnixNano()) {
+			t.Errorf("packet %d: timestamp is %d, expected %d", i, ts, at.UnixNano())
+		}
+		captured := int(binary.LittleEndian.Uint32(b.body[12:16]))
+		if captured != len(packet.frame) || int(binary.LittleEndian.Uint32(b.body[16:20])) != len(packet.frame) {
+			t.Errorf("packet %d: lengths are wrong", i)
+		}
+		if !bytes.Equal(b.body[20:20+captured], packet.frame) {
+			t.Errorf("packet %d: frame is %x, expected %x", i, b.body[20:20+captured], packet.frame)
+		}
+		options := b.body[20+(captured+3)/4*4:]
+		if packet.comment == "" {
+			if len(options) != 0 {
+				t.Errorf("packet %d: unexpected options %x", i, options)
+			}
+			continue
+		}
+		if len(options) < 4 || binary.LittleEndian.Uint16(options[0:2]) != pcapngOptionComment {
+			t.Errorf("packet %d: no comment option: %x", i, options)
+			continue
+		}
+		length := int(binary.LittleEndian.Uint16(options[2:4]))
+		if got := string(options[4 : 4+length]); got != packet.comment {
+			t.Errorf("packet %d: comment is %q, expected %q", i, got, packet.comment)
+		}
+	}
+}
+
+func TestDescribeFrame(t *testing.T) {
+	tests := []struct {
+		source      int
+		destination int
+		recipients  []int
+		expected    string
+	}{
+		{1, 2, []int{2}, "source=1 destination=2 delivered recipients=2"},
+		{1, 0, []int{2, 3}, "source=1 destination=group/unknown delivered recipients=2,3"},
+		{1, 2, nil, "source=1 destination=2 dropped"},
+	}
+	for _, test := range tests {
+		if got := describeFrame(test.source, test.destination, test.recipients); got != test.expected {
+			t.Errorf("got %q, expected %q", got, test.expected)
+		}
+	}
+}
+
+func TestCaptureClose(t *testing.T) {
+	dir := t.TempDir()
+	c := newCapture(newDilatedClock(1))
+	c.Configure(&etcd.Node{Key: "/capture", Dir: true, Nodes: []*etcd.Node{
+		{Key: "/capture/enabled", Value: "true"},
+		{Key: "/capture/dir", Value: dir},
+	}})
+	frame := make([]byte, 60)
+	for i := 0; i < 10; i++ {
+		c.record(frame, 1, 2, []int{2})
+	}
+	c.Close()
+	files, err := filepath.Glob(filepath.Join(dir, "all-*.pcapng"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("capture files are %v (%v)", files, err)
+	}
+	data, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if blocks := readPcapngBlocks(t, data); len(blocks) != 2+10 {
+		t.Errorf("%d blocks, expected %d", len(blocks), 2+10)
+	}
+	c.record(frame, 1, 2, []int{2})
+	c.Close()
+}
@@ -48,7 +48,7 @@ func (p *PositionManager) Get(index int) (pos squirrel.Position, err error) {
 	}
 	p.mu[index].RLock()
 	defer p.mu[index].RUnlock()
-	if !p.isEnabled[index] {
+	if !p.IsEnabled(index) {
 		err = fmt.Errorf("node with index %d is disabled", index)
 		return
 	}
@@ -110,7 +110,7 @@ func (p *PositionManager) Set(index int, x, y, height float64) (err error) {
 	}
 	p.mu[index].Lock()
 	defer p.mu[index].Unlock()
-	if !p.isEnabled[index] {
+	if !p.IsEnabled(index) {
 		err = fmt.Errorf("node with index %d is disabled", index)
 		return
 	}
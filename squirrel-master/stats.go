@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/squirrel-land/squirrel"
+)
+
+// linkKey identifies a directed link between two identities.
+type linkKey struct {
+	Source      int
+	Destination int
+}
+
+type linkCounters struct {
+	Delivered  uint64
+	Corrupted  uint64
+	Duplicated uint64
+	Reordered  uint64
+	Retries    uint64
+
+	// Dropped counts frames September decided not to deliver, and those
+	// dropped because of a full outgoing queue, by reason.
+	Dropped [squirrel.NumDropReasons]uint64
+}
+
+type nodeCounters struct {
+	UnknownDestination uint64
+}
+
+// stats keeps counters of the emulated medium. Counters are created lazily and
+// updated atomically so that frameHandler routines don't contend on a lock
+// once a link has been seen.
+type stats struct {
+	// MonitorDropped counts frames not sent to a monitor because its outgoing
+	// queue was full. It's first for 64-bit alignment.
+	MonitorDropped uint64
+
+	links map[linkKey]*linkCounters
+	nodes map[int]*nodeCounters
+	mu    sync.RWMutex
+}
+
+func newStats() *stats {
+	return &stats{links: make(map[linkKey]*linkCounters), nodes: make(map[int]*nodeCounters)}
+}
+
+func (s *stats) node(identity int) *nodeCounters {
+	s.mu.RLock()
+	c, ok := s.nodes[identity]
+	s.mu.RUnlock()
+	if ok {
+		return c
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c, ok = s.nodes[identity]; !ok {
+		c = new(nodeCounters)
+		s.nodes[identity] = c
+	}
+	return c
+}
+
+func (s *stats) link(source, destination int) *linkCounters {
+	key := linkKey{Source: source, Destination: destination}
+	s.mu.RLock()
+	c, ok := s.links[key]
+	s.mu.RUnlock()
+	if ok {
+		return c
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c, ok = s.links[key]; !ok {
+		c = new(linkCounters)
+		s.links[key] = c
+	}
+	return c
+}
+
+type linkStats struct {
+	Source      int    `json:"source"`
+	Destination int    `json:"destination"`
+	Delivered   uint64 `json:"delivered"`
+	Corrupted   uint64 `json:"corrupted"`
+	Duplicated  uint64 `json:"duplicated"`
+	Reordered   uint64 `json:"reordered"`
+	Retries     uint64 `json:"retries"`
+
+	Dropped map[string]uint64 `json:"dropped,omitempty"`
+}
+
+type nodeStats struct {
+	Identity           int    `json:"identity"`
+	UnknownDestination uint64 `json:"unknown_destination"`
+}
+
+type statsSnapshot struct {
+	Nodes          []nodeStats `json:"nodes"`
+	Links          []linkStats `json:"links"`
+	MonitorDropped uint64      `json:"monitor_dropped"`
+}
+
+func (s *stats) snapshot() (ret statsSnapshot) {
+	ret.MonitorDropped = atomic.LoadUint64(&s.MonitorDropped)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ret.Nodes = make([]nodeStats, 0, len(s.nodes))
+	for identity, c := range s.nodes {
+		ret.Nodes = append(ret.Nodes, nodeStats{
+			Identity:           identity,
+			UnknownDestination: atomic.LoadUint64(&c.UnknownDestination),
+		})
+	}
+	sort.Slice(ret.Nodes, func(i, j int) bool { return ret.Nodes[i].Identity < ret.Nodes[j].Identity })
+	ret.Links = make([]linkStats, 0, len(s.links))
+	for key, c := range s.links {
+		l := linkStats{
+			Source:      key.Source,
+			Destination: key.Destination,
+			Delivered:   atomic.LoadUint64(&c.Delivered),
+			Corrupted:   atomic.LoadUint64(&c.Corrupted),
+			Duplicated:  atomic.LoadUint64(&c.Duplicated),
+			Reordered:   atomic.LoadUint64(&c.Reordered),
+			Retries:     atomic.LoadUint64(&c.Retries),
+		}
+		for reason := range c.Dropped {
+			if n := atomic.LoadUint64(&c.Dropped[reason]); n > 0 {
+				if l.Dropped == nil {
+					l.Dropped = make(map[string]uint64)
+				}
+				l.Dropped[squirrel.DropReason(reason).String()] = n
+			}
+		}
+		ret.Links = append(ret.Links, l)
+	}
+	sort.Slice(ret.Links, func(i, j int) bool {
+		if ret.Links[i].Source != ret.Links[j].Source {
+			return ret.Links[i].Source < ret.Links[j].Source
+		}
+		return ret.Links[i].Destination < ret.Links[j].Destination
+	})
+	return
+}
+
+func (s *stats) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(s.snapshot())
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/squirrel-land/squirrel"
+)
+
+// feedbackSeptember decides upon frames as results say, and passes on what it's
+// told about fates of frames.
+type feedbackSeptember struct {
+	deliverEverywhere
+	results  []squirrel.Result
+	feedback chan squirrel.Feedback
+}
+
+func (s *feedbackSeptember) SendUnicast(source int, destination int, frame *squirrel.Frame) squirrel.Result {
+	for _, r := range s.results {
+		if r.Destination == destination {
+			return r
+		}
+	}
+	return squirrel.Result{Destination: destination, Delivered: true}
+}
+
+func (s *feedbackSeptember) SendBroadcast(source int, frame *squirrel.Frame, underlying []squirrel.Result) []squirrel.Result {
+	return append(underlying[:0], s.results...)
+}
+
+func (s *feedbackSeptember) Feedback(f *squirrel.Feedback) {
+	s.feedback <- *f
+}
+
+func newFeedbackMaster(t *testing.T, nodes int) (*Master, *feedbackSeptember, []*testNode) {
+	_, network, _ := net.ParseCIDR("10.0.0.0/24")
+	september := &feedbackSeptember{feedback: make(chan squirrel.Feedback, 256)}
+	master := NewMaster(network, 1, stillMobility{}, september)
+	return master, september, joinTestNodes(t, master, nodes)
+}
+
+func linkSnapshot(master *Master, source, destination int) (l linkStats) {
+	for _, l := range master.stats.snapshot().Links {
+		if l.Source == source && l.Destination == destination {
+			return l
+		}
+	}
+	return
+}
+
+func TestAccount(t *testing.T) {
+	master, september, nodes := newFeedbackMaster(t, 3)
+	broadcast := ethernetFrame(broadcastAddr, 0x0800, make([]byte, 46))
+
+	september.results = []squirrel.Result{
+		{Destination: 2, Delivered: true, Retries: 3},
+		{Destination: 3, Reason: squirrel.DroppedCollision, Retries: 7},
+	}
+	sendFrame(master, 1, broadcast)
+	nodes[2].receive(t)
+
+	// reasons that are not reasons to drop a frame are counted as unknown
+	september.results = []squirrel.Result{
+		{Destination: 2, Reason: squirrel.NotDropped},
+		{Destination: 3, Reason: squirrel.NumDropReasons + 3},
+	}
+	sendFrame(master, 1, broadcast)
+
+	september.results = []squirrel.Result{{Destination: 3, Reason: squirrel.DroppedRetryLimit, Retries: 2}}
+	sendFrame(master, 1, ethernetFrame(nodeMAC(3), 0x0800, make([]byte, 46)))
+	nodes[3].expectNothing(t)
+
+	tests := []struct {
+		destination int
+		delivered   uint64
+		retries     uint64
+		dropped     map[string]uint64
+	}{
+		{2, 1, 3, map[string]uint64{"unknown": 1}},
+		{3, 0, 9, map[string]uint64{"collision": 1, "unknown": 1, "retry_limit": 1}},
+	}
+	for _, test := range tests {
+		l := linkSnapshot(master, 1, test.destination)
+		if l.Delivered != test.delivered || l.Retries != test.retries || !reflect.DeepEqual(l.Dropped, test.dropped) {
+			t.Errorf("link 1 -> %d: got %d delivered, %d retries and dropped %v, expected %d, %d and %v",
+				test.destination, l.Delivered, l.Retries, l.Dropped, test.delivered, test.retries, test.dropped)
+		}
+	}
+}
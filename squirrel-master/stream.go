@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/squirrel-land/squirrel"
+)
+
+// streamFilter selects frames a stream is interested in.
+type streamFilter struct {
+	// identities, if not empty, selects frames sent by, addressed to, or
+	// delivered to any of them.
+	identities map[int]bool
+	delivered  bool
+	dropped    bool
+}
+
+func parseStreamFilter(r *http.Request) (f streamFilter, err error) {
+	f.identities = make(map[int]bool)
+	for _, v := range r.URL.Query()["identity"] {
+		for _, s := range strings.Split(v, ",") {
+			var id int
+			if id, err = strconv.Atoi(s); err != nil {
+				return
+			}
+			f.identities[id] = true
+		}
+	}
+	switch decision := r.URL.Query().Get("decision"); decision {
+	case "", "all":
+		f.delivered, f.dropped = true, true
+	case "delivered":
+		f.delivered = true
+	case "dropped":
+		f.dropped = true
+	default:
+		err = fmt.Errorf("invalid decision %q (expected delivered, dropped, or all)", decision)
+	}
+	return
+}
+
+func (f *streamFilter) match(source int, destination int, recipients []int) bool {
+	if len(recipients) > 0 && !f.delivered || len(recipients) == 0 && !f.dropped {
+		return false
+	}
+	if len(f.identities) == 0 || f.identities[source] || f.identities[destination] {
+		return true
+	}
+	for _, id := range recipients {
+		if f.identities[id] {
+			return true
+		}
+	}
+	return false
+}
+
+type stream struct {
+	filter  streamFilter
+	records chan *captureRecord
+	dropped uint64 // accessed atomically
+}
+
+// streams serves live pcapng streams of frames that go through master over
+// HTTP, so that they can be piped into Wireshark:
+//
+//	curl -s 'http://master:8080/pcap?identity=3&decision=dropped' | wireshark -k -i -
+//
+// Each stream has its own buffer. Frames are dropped from a stream rather
+// than slowing down forwarding if its consumer can't keep up.
+type streams struct {
+	active int32 // number of streams; accessed atomically
+	subs   map[*stream]bool
+	mu     sync.RWMutex
+	clock  squirrel.Clock
+}
+
+func newStreams(clock squirrel.Clock) *streams {
+	return &streams{subs: make(map[*stream]bool), clock: clock}
+}
+
+func (s *streams) record(frame []byte, source int, destination int, recipients []int) {
+	if atomic.LoadInt32(&s.active) == 0 {
+		return
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var r *captureRecord
+	for sub := range s.subs {
+		if !sub.filter.match(source, destination, recipients) {
+			continue
+		}
+		if r == nil {
+			r = &captureRecord{
+				time:        s.clock.Now(),
+				frame:       append([]byte(nil), frame...),
+				source:      source,
+				destination: destination,
+				recipients:  append([]int(nil), recipients...),
+			}
+		}
+		select {
+		case sub.records <- r:
+		default:
+			atomic.AddUint64(&sub.dropped, 1)
+		}
+	}
+}
+
+func (s *streams) subscribe(filter streamFilter) *stream {
+	sub := &stream{filter: filter, records: make(chan *captureRecord, 4096)}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.subs[sub] = true
+	atomic.AddInt32(&s.active, 1)
+	return sub
+}
+
+func (s *streams) unsubscribe(sub *stream) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.subs, sub)
+	atomic.AddInt32(&s.active, -1)
+}
+
+// flushWriter flushes after every write, so that frames reach the consumer as
+// soon as they are written.
+type flushWriter struct {
+	w http.ResponseWriter
+	f http.Flusher
+}
+
+func (fw flushWriter) Write(p []byte) (n int, err error) {
+	n, err = fw.w.Write(p)
+	fw.f.Flush()
+	return
+}
+
+func (s *streams) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseStreamFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	sub := s.subscribe(filter)
+	defer s.unsubscribe(sub)
+	log.Printf("pcap stream to %s started\n", r.RemoteAddr)
+
+	w.Header().Set("Content-Type", "application/x-pcapng")
+	pw, err := newPcapngWriter(flushWriter{w: w, f: flusher})
+	if err != nil {
+		return
+	}
+	for {
+		select {
+		case rec := <-sub.records:
+			if err = pw.WritePacket(rec.time, rec.frame, describeFrame(rec.source, rec.destination, rec.recipients)); err != nil {
+				log.Printf("pcap stream to %s terminated: %v (%d frames dropped)\n", r.RemoteAddr, err, atomic.LoadUint64(&sub.dropped))
+				return
+			}
+		case <-r.Context().Done():
+			log.Printf("pcap stream to %s terminated (%d frames dropped)\n", r.RemoteAddr, atomic.LoadUint64(&sub.dropped))
+			return
+		}
+	}
+}
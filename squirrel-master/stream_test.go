@@ -0,0 +1,96 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseStreamFilter(t *testing.T) {
+	tests := []struct {
+		query      string
+		identities []int
+		delivered  bool
+		dropped    bool
+		err        bool
+	}{
+		{query: "", delivered: true, dropped: true},
+		{query: "decision=all&identity=3", identities: []int{3}, delivered: true, dropped: true},
+		{query: "decision=delivered&identity=3,5&identity=7", identities: []int{3, 5, 7}, delivered: true},
+		{query: "decision=dropped", dropped: true},
+		{query: "decision=lost", err: true},
+		{query: "identity=x", err: true},
+	}
+	for _, test := range tests {
+		f, err := parseStreamFilter(httptest.NewRequest("GET", "/pcap?"+test.query, nil))
+		if test.err {
+			if err == nil {
+				t.Errorf("%q: expected an error", test.query)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", test.query, err)
+			continue
+		}
+		if f.delivered != test.delivered || f.dropped != test.dropped || len(f.identities) != len(test.identities) {
+			t.Errorf("%q: got %+v", test.query, f)
+		}
+		for _, id := range test.identities {
+			if !f.identities[id] {
+				t.Errorf("%q: identity %d missing", test.query, id)
+			}
+		}
+	}
+}
+
+func TestStreamFilterMatch(t *testing.T) {
+	all := streamFilter{delivered: true, dropped: true}
+	node3 := streamFilter{identities: map[int]bool{3: true}, delivered: true, dropped: true}
+	droppedOnly := streamFilter{dropped: true}
+	tests := []struct {
+		name        string
+		filter      streamFilter
+		source      int
+		destination int
+		recipients  []int
+		expected    bool
+	}{
+		{"anything", all, 1, 2, []int{2}, true},
+		{"sent by the identity", node3, 3, 2, nil, true},
+		{"addressed to the identity", node3, 1, 3, nil, true},
+		{"delivered to the identity", node3, 1, 0, []int{2, 3}, true},
+		{"not involving the identity", node3, 1, 0, []int{2, 4}, false},
+		{"dropped only, delivered", droppedOnly, 1, 2, []int{2}, false},
+		{"dropped only, dropped", droppedOnly, 1, 2, nil, true},
+	}
+	for _, test := range tests {
+		if got := test.filter.match(test.source, test.destination, test.recipients); got != test.expected {
+			t.Errorf("%s: match is %v, expected %v", test.name, got, test.expected)
+		}
+	}
+}
+
+func TestStreamsDropForSlowSubscriber(t *testing.T) {
+	s := newStreams(newDilatedClock(1))
+	frame := make([]byte, 60)
+	s.record(frame, 1, 2, []int{2}) // no subscriber; nothing to do
+
+	sub := s.subscribe(streamFilter{identities: map[int]bool{5: true}, delivered: true, dropped: true})
+	capacity := cap(sub.records)
+	for i := 0; i < capacity+10; i++ {
+		s.record(frame, 5, 2, []int{2})
+	}
+	s.record(frame, 1, 2, []int{2}) // filtered out
+	if len(sub.records) != capacity || sub.dropped != 10 {
+		t.Errorf("%d frames queued and %d dropped, expected %d and 10", len(sub.records), sub.dropped, capacity)
+	}
+	r := <-sub.records
+	if r.source != 5 || len(r.frame) != len(frame) {
+		t.Errorf("queued frame is %+v", r)
+	}
+
+	s.unsubscribe(sub)
+	if s.active != 0 || len(s.subs) != 0 {
+		t.Errorf("%d streams left after unsubscribing", s.active)
+	}
+}
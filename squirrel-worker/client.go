@@ -11,25 +11,36 @@ import (
 )
 
 type Client struct {
-	link *common.Link
-	tap  *water.Interface
+	link    *common.Link
+	tap     *water.Interface
+	name    string
+	monitor bool
 }
 
-// Create a new client along with a TAP network interface whose name is tapName
-func NewClient(tapName string) (client *Client, err error) {
+// Create a new client along with a TAP network interface whose name is tapName.
+// name is what the client tells master to refer to it by; it can be empty.
+// If monitor is true, the client joins as a monitor, which only receives
+// copies of all frames in the emulated network.
+func NewClient(tapName string, name string, monitor bool) (client *Client, err error) {
 	var tap *water.Interface
 	tap, err = water.NewTAP(tapName)
 	if err != nil {
 		return nil, err
 	}
 	client = &Client{
-		link: nil,
-		tap:  tap,
+		link:    nil,
+		tap:     tap,
+		name:    name,
+		monitor: monitor,
 	}
 	return
 }
 
 func (client *Client) configureTap(joinRsp *common.JoinRsp) (err error) {
+	if client.monitor {
+		log.Printf("Joined as monitor. Bringing up %s without address\n", client.tap.Name())
+		return exec.Command("ip", "link", "set", "dev", client.tap.Name(), "up").Run()
+	}
 	m, _ := joinRsp.Mask.Size()
 	addr := fmt.Sprintf("%s/%d", joinRsp.Address.String(), m)
 	log.Printf("Assigning %s to %s\n", addr, client.tap.Name())
@@ -51,7 +62,7 @@ func (client *Client) connect(masterAddr string) (err error) {
 
 	var ifce *net.Interface
 	ifce, err = net.InterfaceByName(client.tap.Name())
-	err = client.link.SendJoinReq(&common.JoinReq{MACAddr: ifce.HardwareAddr})
+	err = client.link.SendJoinReq(&common.JoinReq{MACAddr: ifce.HardwareAddr, Name: client.name, Monitor: client.monitor})
 	if err != nil {
 		return
 	}
@@ -120,7 +131,9 @@ func (client *Client) Start(masterAddr string) (err error) {
 		return
 	}
 
-	go client.tap2master()
+	if !client.monitor {
+		go client.tap2master()
+	}
 	go client.master2tap()
 
 	return
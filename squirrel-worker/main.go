@@ -1,6 +1,7 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"log"
 	"os"
@@ -51,11 +52,21 @@ func printHelp() {
 	fmt.Println("Etcd Configuration Entries:")
 	fmt.Println("    /squirrel/master_uri      : URI of the squirrel-master. [Required]")
 	fmt.Println("    /squirrel/worker_tap_name : Name of the TAP interface.  [Optional]")
+	fmt.Println()
+	fmt.Println("Flags:")
+	flag.PrintDefaults()
 }
 
+var (
+	monitor = flag.Bool("monitor", false, "join as a monitor that receives a copy of every frame in the emulated network, encapsulated with EtherType 0x88b5")
+	name    = flag.String("name", "", "name master and its configuration refer to this node by. Default: hostname")
+)
+
 func main() {
 	log.SetOutput(os.Stdout)
 
+	flag.Parse()
+
 	var (
 		client *Client
 		conf   config
@@ -66,7 +77,10 @@ func main() {
 		printHelp()
 		log.Fatalf("reading config error: %v\n", err)
 	}
-	if client, err = NewClient(conf.tapName); err != nil {
+	if *name == "" {
+		*name, _ = os.Hostname()
+	}
+	if client, err = NewClient(conf.tapName, *name, *monitor); err != nil {
 		log.Fatalf("creating client error: %v\n", err)
 	}
 	if err = client.Start(conf.masterURI); err != nil {
@@ -1,6 +1,10 @@
 package squirrel
 
-import "github.com/coreos/go-etcd/etcd"
+import (
+	"time"
+
+	"github.com/coreos/go-etcd/etcd"
+)
 
 // MobilityManager controls locations and defines model of mobility of each
 // nodes. Master uses an implementation of MobilityManager interface to
@@ -61,6 +65,294 @@ type September interface {
 	SendBroadcast(source int, size int, underlying []int) []int
 }
 
+// SeptemberV2 is like September, but decides upon frames with their content and
+// metadata rather than only their size, so that e.g. ACKs can be told from
+// data, or routing control traffic be treated specially. Master uses
+// SeptemberV2; a September is used through SeptemberAdapter.
+//
+// A September that implements http.Handler is served on Master's HTTP
+// server, at /septembers/<channel>, e.g. to expose its state for debugging.
+type SeptemberV2 interface {
+
+	// ParametersHelp prints help message on how to set parameters
+	ParametersHelp() string
+
+	// Configure configures the September with a set of parameters.
+	Configure(*etcd.Node) error
+
+	// Initialize sets the PositionManager.
+	Initialize(positionManager PositionManager)
+
+	// SendUnicast is used when a unicast frame is sent from source(identity)
+	// to destination(identity).
+	//
+	// Returns the decision for destination.
+	SendUnicast(source int, destination int, frame *Frame) Result
+
+	// SendBroadcast is used when a broadcast frame is sent from
+	// source(identity).
+	//
+	// Returns a sub-slice of underlying with a decision for each node that
+	// should receive the frame, and optionally for nodes it's dropped for, so
+	// that they're accounted for in statistics. underlying is long enough to
+	// hold a decision for every node, as in September.SendBroadcast.
+	SendBroadcast(source int, frame *Frame, underlying []Result) []Result
+}
+
+// DropReason tells why a frame is not delivered to a node.
+type DropReason int
+
+const (
+	// NotDropped is the reason of a frame that is delivered.
+	NotDropped DropReason = iota
+
+	// DroppedUnknown is the reason of a frame dropped for no particular
+	// reason, e.g. by a September that doesn't tell why.
+	DroppedUnknown
+
+	// DroppedOutOfRange is the reason of a frame whose destination can't hear
+	// its source.
+	DroppedOutOfRange
+
+	// DroppedCollision is the reason of a frame lost to interference from
+	// other frames.
+	DroppedCollision
+
+	// DroppedRetryLimit is the reason of a frame given up on after as many
+	// retransmissions as the MAC allows.
+	DroppedRetryLimit
+
+	// DroppedQueueFull is the reason of a frame that doesn't fit in the
+	// transmit queue.
+	DroppedQueueFull
+
+	// NumDropReasons is the number of DropReasons.
+	NumDropReasons
+)
+
+var dropReasonNames = [NumDropReasons]string{"not_dropped", "unknown", "out_of_range", "collision", "retry_limit", "queue_full"}
+
+func (r DropReason) String() string {
+	if r < 0 || r >= NumDropReasons {
+		return "invalid"
+	}
+	return dropReasonNames[r]
+}
+
+// Result is the decision September makes upon a frame for one node.
+type Result struct {
+
+	// Destination is the identity of the node the decision is for.
+	Destination int
+
+	// Delivered is whether the frame should be delivered to Destination.
+	Delivered bool
+
+	// Reason is why the frame is not delivered. It's NotDropped if Delivered
+	// is true.
+	Reason DropReason
+
+	// Retries is the number of retransmissions the frame took, or would have
+	// taken before being given up on.
+	Retries int
+
+	// SINR is the signal to interference plus noise ratio(in dB) of the
+	// frame at Destination, or NaN if it's not modeled.
+	SINR float64
+}
+
+// Fate is what eventually happens to a frame September decided to deliver.
+type Fate int
+
+const (
+	// FateWritten is the fate of a frame written to the Link of its
+	// destination.
+	FateWritten Fate = iota
+
+	// FateFiltered is the fate of a frame Master dropped after the decision,
+	// because of an ACL, multicast snooping, or an interceptor.
+	FateFiltered
+
+	// FateDestinationLeft is the fate of a frame whose destination left, or
+	// whose Link to Master failed, before the frame was written.
+	FateDestinationLeft
+
+	// FateQueueFull is the fate of a frame dropped because the outgoing queue
+	// of the Link of its destination was full.
+	FateQueueFull
+)
+
+// Feedback tells the fate of a frame September decided to deliver.
+type Feedback struct {
+
+	// Source and Destination are identities of the nodes the decision was
+	// made for.
+	Source      int
+	Destination int
+
+	// Meta is metadata of the frame the decision was made upon.
+	Meta FrameMeta
+
+	// Fate is what happened to the frame.
+	Fate Fate
+
+	// Time is when the fate was known, in emulated time. For FateWritten,
+	// it's when the frame was written to the Link of Destination.
+	Time time.Time
+}
+
+// FeedbackReceiver can optionally be implemented by a September that needs to
+// know what happens to frames after its decisions, e.g. to keep track of
+// channel occupancy accurately.
+type FeedbackReceiver interface {
+
+	// Feedback is called once for each node a frame was decided to be
+	// delivered to, once the fate of the frame is known. It may be called from
+	// any routine, and concurrently with other methods. feedback must not be
+	// retained after it returns.
+	Feedback(feedback *Feedback)
+}
+
+// BitErrorRater can optionally be implemented by a September to drive frame
+// corruption. If the September implements it, Master uses the returned rate
+// instead of the one from its own configuration.
+type BitErrorRater interface {
+
+	// BitErrorRate returns the probability that any single bit of a frame sent
+	// from source(identity) to destination(identity) is flipped on delivery.
+	BitErrorRate(source int, destination int) float64
+}
+
+// Seeder can optionally be implemented by a MobilityManager or a September
+// that uses randomness. If a seed is configured, Master calls Seed before
+// Configure, so that runs with the same seed are reproducible.
+type Seeder interface {
+	Seed(seed int64)
+}
+
+// Clock is the source of time in the emulation. It runs slower than the wall
+// clock when Master is configured with a time dilation factor, so that large
+// topologies can be emulated on a host that can't keep up in real time.
+// MobilityManager and September should use it instead of package time for
+// anything that relates to the emulated network.
+type Clock interface {
+
+	// Now returns the current emulated time.
+	Now() time.Time
+
+	// Since returns the emulated time elapsed since t.
+	Since(t time.Time) time.Duration
+
+	// Sleep pauses the current goroutine for at least d of emulated time.
+	Sleep(d time.Duration)
+
+	// After waits for d of emulated time to elapse and then sends the current
+	// emulated time on the returned channel.
+	After(d time.Duration) <-chan time.Time
+
+	// NewTicker returns a time.Ticker that ticks every d of emulated time.
+	// Values sent on its channel are wall clock times.
+	NewTicker(d time.Duration) *time.Ticker
+}
+
+// ClockUser can optionally be implemented by a MobilityManager or a September.
+// Master calls SetClock before Initialize.
+type ClockUser interface {
+	SetClock(clock Clock)
+}
+
+// NodeDirectory resolves how configuration refers to nodes into identities.
+type NodeDirectory interface {
+
+	// Identity returns the identity of the joined node that node refers to,
+	// which is either the name the node joined with (e.g. its hostname), or
+	// its hardware address. ok is false if there isn't one.
+	Identity(node string) (identity int, ok bool)
+}
+
+// NodeDirectoryUser can optionally be implemented by a September that refers
+// to nodes by names or hardware addresses. Master calls SetNodeDirectory
+// before Initialize. Nodes are in the directory by the time they're enabled in
+// PositionManager.
+type NodeDirectoryUser interface {
+	SetNodeDirectory(directory NodeDirectory)
+}
+
+// Reconfigurer can optionally be implemented by a September whose
+// configuration can change at runtime. Master watches the configuration node
+// of the September, and calls Reconfigure with it, or nil if it's removed,
+// whenever anything under it changes. Reconfigure may be called concurrently
+// with decisions. If it returns an error, the September should keep its
+// current configuration.
+type Reconfigurer interface {
+	Reconfigure(conf *etcd.Node) error
+}
+
+// InterceptedFrame is a frame going through Master, as seen by a
+// FrameInterceptor.
+type InterceptedFrame struct {
+
+	// Source is the identity of the node that sent the frame.
+	Source int
+
+	// Destination is the identity of the node the frame is addressed to, or 0
+	// if it's addressed to a group or an unknown address.
+	Destination int
+
+	// Data is the Ethernet frame. Interceptors may modify it in place, or
+	// replace it with another slice.
+	Data []byte
+
+	// Delay, if set by an interceptor, holds the frame back for that long (in
+	// emulated time) after all interceptors in the chain have seen it.
+	Delay time.Duration
+}
+
+// FrameInterceptor is used by Master to let custom code act upon frames
+// around decisions made by September. Master has an ordered chain of them,
+// and calls each hook of every interceptor in the chain, in order, for each
+// frame.
+type FrameInterceptor interface {
+
+	// ParametersHelp prints help message on how to set parameters
+	ParametersHelp() string
+
+	// Configure configures the interceptor with a set of parameters.
+	Configure(*etcd.Node) error
+
+	// BeforeDecision is called when a frame is read from a node, before
+	// September decides upon it.
+	//
+	// Returns whether the frame should proceed. If any interceptor returns
+	// false, the frame is dropped and following interceptors don't see it.
+	BeforeDecision(frame *InterceptedFrame) bool
+
+	// AfterDecision is called after September decided upon a frame, with
+	// identities of nodes that are to receive it, which can be empty.
+	//
+	// Returns identities of nodes the frame should be delivered to. recipients
+	// can be modified in place and a sub-slice of it returned, or a different
+	// slice can be returned.
+	AfterDecision(frame *InterceptedFrame, recipients []int) []int
+}
+
+// FrameInjector is implemented by Master to let a FrameInterceptor inject
+// frames of its own.
+type FrameInjector interface {
+
+	// Inject handles data as if it was an Ethernet frame sent by
+	// source(identity). It's decided upon by September and seen by
+	// AfterDecision hooks, but not by BeforeDecision hooks. data is copied and
+	// left intact.
+	Inject(source int, data []byte)
+}
+
+// InjectorUser can optionally be implemented by a FrameInterceptor. Master
+// calls SetInjector after Configure, before the interceptor sees any frame.
+type InjectorUser interface {
+	SetInjector(injector FrameInjector)
+}
+
 type Position struct {
 	X      float64
 	Y      float64